@@ -12,6 +12,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 )
@@ -24,6 +25,21 @@ var discordToken string
 var discordbotsToken string
 var discordbotsUpdateInterval time.Duration
 var stringLevel string
+var comparisonResolvers string
+var upstreamTransport string
+var upstreamAddress string
+var upstreamHTTPMethod string
+var upstreamTimeout time.Duration
+var tlsServerName string
+var tlsCAFile string
+var spkiPins string
+var ecsTransport string
+var ecsUpstreamAddress string
+var geoPresets string
+var watchFile string
+var cacheSize int
+var staleWindow time.Duration
+var statisticsInterval time.Duration
 
 func main() {
 	logrus.WithField("name", applicationName).WithField("version", version).WithField("branch", branch).WithField("commit", commit).Print("starting application...")
@@ -31,6 +47,21 @@ func main() {
 	flag.StringVar(&discordToken, "token", "", "The Discord Bot token which should be used to authenticate with the Discord API.")
 	flag.StringVar(&discordbotsToken, "discordbotstoken", "", "The discordbots.org token which is used to update the bot's stats.")
 	flag.DurationVar(&discordbotsUpdateInterval, "discordbotsinterval", time.Minute*30, "The interval in which an update is sent to the discordbots.org API.")
+	flag.StringVar(&comparisonResolvers, "compareresolvers", "", "A comma separated list of additional resolvers (ip[:port]) which are queried by the compare command.")
+	flag.StringVar(&upstreamTransport, "transport", discord1111resolver.TransportTLS, "The transport used to reach the upstream resolver (dot, udp, tcp or doh).")
+	flag.StringVar(&upstreamAddress, "upstream", "", "The address (host:port) or DNS over HTTPS URL of the upstream resolver. Defaults to the 1.1.1.1 DNS service.")
+	flag.StringVar(&upstreamHTTPMethod, "dohmethod", "POST", "The HTTP method (GET or POST) used for DNS over HTTPS.")
+	flag.DurationVar(&upstreamTimeout, "upstreamtimeout", time.Second*5, "The timeout of a single query to the upstream resolver.")
+	flag.StringVar(&tlsServerName, "tlsservername", "", "The server name which is verified against the certificate of a DNS over TLS upstream. Defaults to the host of the upstream address.")
+	flag.StringVar(&tlsCAFile, "tlscafile", "", "A PEM encoded CA bundle which is used instead of the system pool to verify a DNS over TLS upstream.")
+	flag.StringVar(&spkiPins, "spkipins", "", "A comma separated list of base64 encoded SPKI SHA-256 pins of which one has to match the DNS over TLS upstream certificate chain.")
+	flag.StringVar(&ecsTransport, "ecstransport", discord1111resolver.TransportTLS, "The transport used to reach the EDNS Client Subnet honouring resolver (dot, udp, tcp or doh).")
+	flag.StringVar(&ecsUpstreamAddress, "ecsupstream", "8.8.8.8:853", "The address (host:port) or DNS over HTTPS URL of the resolver which answers --geo queries. It has to honour EDNS Client Subnet.")
+	flag.StringVar(&geoPresets, "geopresets", discord1111resolver.DefaultGeoPresets, "A comma separated list of client subnet presets (name=prefix) which can be selected with the --geo option.")
+	flag.IntVar(&cacheSize, "cachesize", discord1111resolver.DefaultCacheSize, "The maximum number of responses which are cached until their TTL expires. Caching is disabled if it is 0.")
+	flag.DurationVar(&staleWindow, "stalewindow", discord1111resolver.DefaultStaleWindow, "The time expired responses are served while the upstream resolver cannot be reached (RFC 8767). Stale responses are disabled if it is 0.")
+	flag.DurationVar(&statisticsInterval, "statsinterval", time.Hour, "The interval in which the resolver statistics (e.g. the number of collapsed queries) are logged. They are only logged at shutdown if it is 0.")
+	flag.StringVar(&watchFile, "watchfile", "watches.json", "The JSON file the watch subscriptions are persisted to. Subscriptions are only kept in memory if it is empty.")
 	flag.Parse()
 	// parse level from user input
 	level, err := logrus.ParseLevel(stringLevel)
@@ -45,6 +76,38 @@ func main() {
 		flag.PrintDefaults()
 		os.Exit(1)
 	}
+	logrus.Debug("creating upstream resolver...")
+	upstreamConfig := discord1111resolver.UpstreamConfig{
+		Transport:     upstreamTransport,
+		Address:       upstreamAddress,
+		HTTPMethod:    upstreamHTTPMethod,
+		Timeout:       upstreamTimeout,
+		TLSServerName: tlsServerName,
+		TLSCAFile:     tlsCAFile,
+		SPKIPins:      splitList(spkiPins),
+	}
+	upstream, err := discord1111resolver.NewUpstream(upstreamConfig)
+	if err != nil {
+		logrus.WithError(err).WithField("transport", upstreamTransport).Fatal("could not create upstream resolver")
+	}
+	logrus.WithField("upstream", upstream.String()).Info("using upstream resolver")
+	profileUpstreams, err := discord1111resolver.NewProfileUpstreams(upstreamConfig)
+	if err != nil {
+		logrus.WithError(err).WithField("transport", upstreamTransport).Fatal("could not create resolver profile upstreams")
+	}
+	ecsUpstream, err := discord1111resolver.NewUpstream(discord1111resolver.UpstreamConfig{
+		Transport:  ecsTransport,
+		Address:    ecsUpstreamAddress,
+		HTTPMethod: upstreamHTTPMethod,
+		Timeout:    upstreamTimeout,
+	})
+	if err != nil {
+		logrus.WithError(err).WithField("transport", ecsTransport).Fatal("could not create EDNS Client Subnet upstream resolver")
+	}
+	parsedGeoPresets, err := discord1111resolver.ParseGeoPresets(geoPresets)
+	if err != nil {
+		logrus.WithError(err).WithField("geo-presets", geoPresets).Fatal("could not parse client subnet presets")
+	}
 	logrus.Debug("connecting to Discord API...")
 	session, err := discordgo.New(fmt.Sprintf("Bot %v", discordToken))
 	if err != nil {
@@ -76,10 +139,27 @@ func main() {
 		DNSClient: &dns.Client{
 			Net: "tcp-tls", // enable DNS over TLS
 		},
-		DiscordBotUser: user,
+		Upstream:            upstream,
+		Profiles:            profileUpstreams,
+		ECSUpstream:         ecsUpstream,
+		GeoPresets:          parsedGeoPresets,
+		DiscordBotUser:      user,
+		ComparisonResolvers: splitList(comparisonResolvers),
+		CacheSize:           cacheSize,
+		StaleWindow:         staleWindow,
+		WatchFile:           watchFile,
 	}
 	resolveHandler.Initialize()
+	logrus.Debug("starting watch subscriptions...")
+	if err := resolveHandler.StartWatches(session); err != nil {
+		logrus.WithError(err).WithField("file", watchFile).Fatal("could not load watch subscriptions")
+	}
 	session.AddHandler(resolveHandler.Handle)
+	var statisticsExitChan chan interface{}
+	if statisticsInterval > 0 {
+		statisticsExitChan = make(chan interface{})
+		go logStatistics(resolveHandler, statisticsExitChan)
+	}
 	// Wait here until CTRL-C or other term signal is received.
 	logrus.Info("Bot is now running. Press CTRL-C to exit.")
 	sc := make(chan os.Signal, 1)
@@ -89,6 +169,15 @@ func main() {
 		logrus.Debug("stopping discordbots.org update task...")
 		discordbotsUpdateExitChan <- struct{}{}
 	}
+	if statisticsExitChan != nil {
+		logrus.Debug("stopping statistics task...")
+		statisticsExitChan <- struct{}{}
+	}
+	logrus.WithField("collapsed-queries", resolveHandler.CollapsedQueries()).Info("collapsed identical in-flight queries")
+	logrus.Debug("stopping watch subscriptions...")
+	resolveHandler.StopWatches()
+	logrus.Debug("stopping propagation check refreshes...")
+	resolveHandler.StopPropagationRefreshes()
 	logrus.Debug("closing Discord session...")
 	if err := session.Close(); err != nil {
 		logrus.WithError(err).Warn("could not close discord session")
@@ -97,6 +186,29 @@ func main() {
 	os.Exit(0)
 }
 
+// splitList splits a comma separated flag value and ignores empty entries.
+func splitList(value string) []string {
+	var entries []string
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// logStatistics logs the resolver statistics in the configured interval until the exit channel receives a value.
+func logStatistics(resolveHandler *discord1111resolver.ResolveHandler, exitChannel chan interface{}) {
+	for {
+		select {
+		case <-exitChannel:
+			return
+		case <-time.After(statisticsInterval):
+			logrus.WithField("collapsed-queries", resolveHandler.CollapsedQueries()).Info("collapsed identical in-flight queries")
+		}
+	}
+}
+
 type discordbotsUpdater struct {
 	http.Client
 	discordSession *discordgo.Session
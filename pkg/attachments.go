@@ -0,0 +1,168 @@
+package discord1111resolver
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/bwmarrin/discordgo"
+	"github.com/miekg/dns"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// maximumEmbedFields is the maximum number of fields of a single Discord message embed.
+	maximumEmbedFields = 25
+	// maximumEmbedLength is the maximum number of characters of all texts of a single Discord message embed.
+	maximumEmbedLength = 6000
+	// maximumDescriptionLength is the maximum length of a Discord message embed description.
+	maximumDescriptionLength = 2048
+	// truncatedFieldsFormat is used to point out how many fields did not fit into the message embed.
+	truncatedFieldsFormat = "%d more fields did not fit into this message, see the attached file."
+	// textAttachmentContentType is the content type of the attached presentation format dumps.
+	textAttachmentContentType = "text/plain; charset=utf-8"
+	// wireAttachmentContentType is the content type of the attached wire format messages.
+	wireAttachmentContentType = "application/dns-message"
+	// maximumMessageFiles is the maximum number of files attached to a single Discord message.
+	maximumMessageFiles = 10
+	// replyAttachmentName is the file name of the attachment which contains all fields of a truncated reply.
+	replyAttachmentName = "reply.txt"
+	// maximumAttachmentSize is the maximum size of an attachment which is downloaded from a Discord message.
+	maximumAttachmentSize = 1 << 20
+	// attachmentDownloadTimeout is the timeout of downloading a single attachment.
+	attachmentDownloadTimeout = 10 * time.Second
+)
+
+// attachmentClient is the HTTP client used to download the attachments of Discord messages.
+var attachmentClient = &http.Client{Timeout: attachmentDownloadTimeout}
+
+// embedLength returns the number of characters of all texts of the message embed which count towards the Discord
+// limit.
+func embedLength(messageEmbed *discordgo.MessageEmbed) int {
+	length := utf8.RuneCountInString(messageEmbed.Title) + utf8.RuneCountInString(messageEmbed.Description)
+	for _, field := range messageEmbed.Fields {
+		length += utf8.RuneCountInString(field.Name) + utf8.RuneCountInString(field.Value)
+	}
+	if messageEmbed.Footer != nil {
+		length += utf8.RuneCountInString(messageEmbed.Footer.Text)
+	}
+	return length
+}
+
+// exceedsEmbedLimits checks whether the message embed has too many fields or characters to be sent.
+func exceedsEmbedLimits(messageEmbed *discordgo.MessageEmbed) bool {
+	return len(messageEmbed.Fields) > maximumEmbedFields || embedLength(messageEmbed) > maximumEmbedLength
+}
+
+// attachDNSResponse attaches the presentation format (what dig prints) and, if requested, the wire format of the DNS
+// response to the message.
+func attachDNSResponse(messageSend *discordgo.MessageSend, domain string, response *dns.Msg, wireFormat bool) {
+	fileName := strings.TrimSuffix(domain, ".")
+	messageSend.Files = append(messageSend.Files, &discordgo.File{
+		Name:        fileName + ".txt",
+		ContentType: textAttachmentContentType,
+		Reader:      strings.NewReader(response.String()),
+	})
+	if !wireFormat {
+		return
+	}
+	packedResponse, err := response.Pack()
+	if err != nil {
+		appendDescription(messageSend.Embed, fmt.Sprintf("The wire format could not be attached: %s", err.Error()))
+		return
+	}
+	messageSend.Files = append(messageSend.Files, &discordgo.File{
+		Name:        fileName + ".bin",
+		ContentType: wireAttachmentContentType,
+		Reader:      bytes.NewReader(packedResponse),
+	})
+}
+
+// enforceEmbedLimits makes sure that the message embed can be sent. Overlong field names and values are shortened.
+// Fields which do not fit are removed and, unless the message already carries attachments, attached as a text file.
+func enforceEmbedLimits(messageSend *discordgo.MessageSend) {
+	messageEmbed := messageSend.Embed
+	messageEmbed.Description = truncateText(messageEmbed.Description, maximumDescriptionLength)
+	originalFields := messageEmbed.Fields
+	fields := make([]*discordgo.MessageEmbedField, len(originalFields))
+	for index, field := range originalFields {
+		fields[index] = field
+		name, value := truncateText(field.Name, maximumNameLength), truncateText(field.Value, maximumValueLength)
+		// the fields are copied because they may be shared between messages (e.g. the help fields)
+		if name != field.Name || value != field.Value {
+			fields[index] = &discordgo.MessageEmbedField{Name: name, Value: value, Inline: field.Inline}
+		}
+	}
+	messageEmbed.Fields = fields
+	if !exceedsEmbedLimits(messageEmbed) && !fieldsShortened(originalFields, fields) {
+		return
+	}
+	// the attachment contains the complete fields
+	if len(messageSend.Files) == 0 {
+		text := &bytes.Buffer{}
+		for _, field := range originalFields {
+			fmt.Fprintf(text, "%s\n%s\n\n", field.Name, field.Value)
+		}
+		messageSend.Files = append(messageSend.Files, &discordgo.File{
+			Name:        replyAttachmentName,
+			ContentType: textAttachmentContentType,
+			Reader:      strings.NewReader(text.String()),
+		})
+	}
+	if !exceedsEmbedLimits(messageEmbed) {
+		return
+	}
+	notice := &discordgo.MessageEmbedField{Name: "Reply truncated:"}
+	for keptFields := len(fields) - 1; keptFields >= 0; keptFields-- {
+		notice.Value = fmt.Sprintf(truncatedFieldsFormat, len(fields)-keptFields)
+		messageEmbed.Fields = append(fields[:keptFields:keptFields], notice)
+		if !exceedsEmbedLimits(messageEmbed) {
+			return
+		}
+	}
+}
+
+// fieldsShortened checks whether enforceEmbedLimits had to replace any field with a shortened copy.
+func fieldsShortened(originalFields []*discordgo.MessageEmbedField, fields []*discordgo.MessageEmbedField) bool {
+	for index, field := range fields {
+		if field != originalFields[index] {
+			return true
+		}
+	}
+	return false
+}
+
+// truncateText shortens the text to the given number of characters (Discord counts characters, not bytes).
+func truncateText(text string, maximumLength int) string {
+	if utf8.RuneCountInString(text) <= maximumLength {
+		return text
+	}
+	return string([]rune(text)[:maximumLength-3]) + "..."
+}
+
+// downloadAttachment downloads the content of an attachment of a Discord message. Attachments which are larger than
+// maximumAttachmentSize are rejected.
+func downloadAttachment(attachment *discordgo.MessageAttachment) ([]byte, error) {
+	if attachment.Size > maximumAttachmentSize {
+		return nil, fmt.Errorf("the attachment is larger than %d bytes", maximumAttachmentSize)
+	}
+	response, err := attachmentClient.Get(attachment.URL)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected HTTP status %q", response.Status)
+	}
+	content, err := ioutil.ReadAll(io.LimitReader(response.Body, maximumAttachmentSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(content) > maximumAttachmentSize {
+		return nil, fmt.Errorf("the attachment is larger than %d bytes", maximumAttachmentSize)
+	}
+	return content, nil
+}
@@ -0,0 +1,160 @@
+package discord1111resolver
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/bwmarrin/discordgo"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// batchTypeSeparator separates the record types of a batch query (e.g. A,AAAA,MX).
+	batchTypeSeparator = ","
+	// maximumBatchQueries is the maximum number of queries (types times domains) of a single batch query.
+	maximumBatchQueries = 20
+	// batchQueryNameFormat is used to name the field of a single query of a batch query.
+	batchQueryNameFormat = "%s %s"
+	// batchSummaryFormat is used to summarize a batch query in the footer.
+	batchSummaryFormat = "%d of %d queries answered successfully in %v."
+	// batchAttachmentName is the file name of the attachment which contains the merged responses of a batch query.
+	batchAttachmentName = "batch.txt"
+	// batchAttachmentHeaderFormat separates the responses in the merged attachment of a batch query.
+	batchAttachmentHeaderFormat = ";; %s\n%s\n"
+	// omittedAttachmentsFormat is used to point out how many attachments did not fit into the message.
+	omittedAttachmentsFormat = "%d wire format attachments were omitted, a message may carry at most %d files."
+)
+
+// batchQuery contains a single query of a batch query and its result.
+type batchQuery struct {
+	// recordType is the name of the queried record type.
+	recordType string
+	// domain is the queried domain name or IP address as specified by the user.
+	domain string
+	// messageSend contains the reply which would have been sent if the query had been executed on its own.
+	messageSend *messageReply
+	// ok is true if the query was answered successfully.
+	ok bool
+}
+
+// isBatchQuery checks whether the parameters contain several record types or domains.
+func isBatchQuery(params []string) bool {
+	return len(params) > 2 || (len(params) == 2 && strings.Contains(params[0], batchTypeSeparator))
+}
+
+// handleBatchQuery handles a query with the parameters "<type>[,type...] <domain> [domain...]". Every combination of
+// record type and domain is queried concurrently and the results are grouped into a single message embed.
+func (resolveHandler *ResolveHandler) handleBatchQuery(messageCreate *discordgo.MessageCreate,
+	messageSend *messageReply, options *queryOptions, params []string) (ok bool) {
+	messageEmbed := messageSend.Embed
+	var recordTypes []string
+	for _, messageTypeString := range strings.Split(params[0], batchTypeSeparator) {
+		recordType, valid := validateDNSMessageTypeParam(messageEmbed, messageTypeString)
+		if !valid {
+			return false
+		}
+		recordTypes = append(recordTypes, recordType.name)
+	}
+	domains := params[1:]
+	if len(recordTypes)*len(domains) > maximumBatchQueries {
+		messageEmbed.Fields = []*discordgo.MessageEmbedField{{
+			Name:   "Too many queries:",
+			Value:  fmt.Sprintf("A single message may contain at most %d queries (types times domains).", maximumBatchQueries),
+			Inline: true,
+		}}
+		return false
+	}
+	queries := make([]*batchQuery, 0, len(recordTypes)*len(domains))
+	for _, domain := range domains {
+		for _, recordType := range recordTypes {
+			queries = append(queries, &batchQuery{recordType: recordType, domain: domain})
+		}
+	}
+	start := time.Now()
+	waitGroup := &sync.WaitGroup{}
+	for _, query := range queries {
+		waitGroup.Add(1)
+		go func(query *batchQuery) {
+			defer waitGroup.Done()
+			query.messageSend = &messageReply{MessageSend: &discordgo.MessageSend{Embed: &discordgo.MessageEmbed{}}}
+			query.ok = resolveHandler.handleQuery(messageCreate, query.messageSend, options,
+				[]string{query.recordType, query.domain})
+		}(query)
+	}
+	waitGroup.Wait()
+	duration := time.Since(start)
+	successfulQueries := 0
+	var files []*discordgo.File
+	messageEmbed.Fields = make([]*discordgo.MessageEmbedField, 0, len(queries))
+	for _, query := range queries {
+		marker := failureMarker
+		if query.ok {
+			successfulQueries++
+			marker = successMarker
+		}
+		value := marker + " " + renderBatchQueryResult(query.messageSend.Embed)
+		trimDiscordFieldValue(&value)
+		messageEmbed.Fields = append(messageEmbed.Fields, &discordgo.MessageEmbedField{
+			Name:  truncateText(fmt.Sprintf(batchQueryNameFormat, query.recordType, query.domain), maximumNameLength),
+			Value: value,
+		})
+		files = append(files, query.messageSend.Files...)
+	}
+	attachBatchFiles(messageSend, files)
+	messageEmbed.Footer = &discordgo.MessageEmbedFooter{
+		Text: fmt.Sprintf(batchSummaryFormat, successfulQueries, len(queries), duration),
+	}
+	return successfulQueries == len(queries)
+}
+
+// attachBatchFiles attaches the files of the single queries. If there are more than Discord allows, the presentation
+// format dumps are merged into a single file and the wire format files which do not fit anymore are omitted.
+func attachBatchFiles(messageSend *messageReply, files []*discordgo.File) {
+	if len(files) <= maximumMessageFiles {
+		messageSend.Files = append(messageSend.Files, files...)
+		return
+	}
+	merged := &bytes.Buffer{}
+	var wireFiles []*discordgo.File
+	for _, file := range files {
+		if file.ContentType != textAttachmentContentType {
+			wireFiles = append(wireFiles, file)
+			continue
+		}
+		content, err := ioutil.ReadAll(file.Reader)
+		if err != nil {
+			content = []byte(err.Error())
+		}
+		fmt.Fprintf(merged, batchAttachmentHeaderFormat, file.Name, content)
+	}
+	messageSend.Files = append(messageSend.Files, &discordgo.File{
+		Name:        batchAttachmentName,
+		ContentType: textAttachmentContentType,
+		Reader:      merged,
+	})
+	if omitted := len(messageSend.Files) + len(wireFiles) - maximumMessageFiles; omitted > 0 {
+		wireFiles = wireFiles[:len(wireFiles)-omitted]
+		appendDescription(messageSend.Embed, fmt.Sprintf(omittedAttachmentsFormat, omitted, maximumMessageFiles))
+	}
+	messageSend.Files = append(messageSend.Files, wireFiles...)
+}
+
+// renderBatchQueryResult flattens the message embed of a single query into one field value.
+func renderBatchQueryResult(messageEmbed *discordgo.MessageEmbed) string {
+	result := &bytes.Buffer{}
+	if messageEmbed.Description != "" {
+		result.WriteString(messageEmbed.Description)
+	}
+	for _, field := range messageEmbed.Fields {
+		if result.Len() > 0 {
+			result.WriteString("\n")
+		}
+		fmt.Fprintf(result, "%s %s", field.Name, field.Value)
+	}
+	if result.Len() == 0 {
+		result.WriteString("Invalid query.")
+	}
+	return result.String()
+}
@@ -0,0 +1,203 @@
+package discord1111resolver
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"github.com/bwmarrin/discordgo"
+	"github.com/miekg/dns"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultBenchQueries is the number of queries per variant if the bench command does not specify a count.
+	defaultBenchQueries = 20
+	// maximumBenchQueries is the maximum number of queries per variant of the bench command.
+	maximumBenchQueries = 100
+	// benchConcurrency is the number of queries of the bench command which are in flight at the same time.
+	benchConcurrency = 5
+	// benchRandomLabelLength is the number of random bytes (hex encoded) of the label which busts the resolver cache.
+	benchRandomLabelLength = 6
+	// benchHistogramWidth is the maximum number of characters of a histogram bar.
+	benchHistogramWidth = 20
+	// benchHistogramRowFormat is used to render a single row of the latency histogram.
+	benchHistogramRowFormat = "%-9s %-*s %d\n"
+)
+
+// benchHistogramBuckets contains the upper bounds of the latency histogram buckets. The last bucket is unbounded.
+var benchHistogramBuckets = []time.Duration{
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	20 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	200 * time.Millisecond,
+	500 * time.Millisecond,
+}
+
+// benchVariant describes a kind of query sent by the bench command.
+type benchVariant struct {
+	// name is shown to Discord users.
+	name string
+	// domain returns the domain name which is queried for the benchmarked domain.
+	domain func(domain string) string
+}
+
+// benchVariants contains the query variants of the bench command.
+var benchVariants = []benchVariant{
+	{
+		name: "Cache hit",
+		domain: func(domain string) string {
+			return domain
+		},
+	},
+	{
+		name: "Cache miss (random label)",
+		domain: func(domain string) string {
+			return randomBenchLabel() + "." + domain
+		},
+	},
+}
+
+// benchResult contains the latencies and failures of the queries of a single variant.
+type benchResult struct {
+	durations []time.Duration
+	errors    int
+}
+
+func (resolveHandler *ResolveHandler) handleBenchCommand(messageCreate *discordgo.MessageCreate,
+	messageSend *messageReply, options *queryOptions, params []string) (ok bool) {
+	messageEmbed := messageSend.Embed
+	if len(params) < 1 || len(params) > 2 {
+		return false
+	}
+	domain, ok := prepareDomainName(messageEmbed, params[0])
+	if !ok {
+		return false
+	}
+	count := defaultBenchQueries
+	if len(params) == 2 {
+		var err error
+		if count, err = strconv.Atoi(params[1]); err != nil || count < 1 || count > maximumBenchQueries {
+			countString := params[1]
+			trimDiscordFieldValue(&countString)
+			messageEmbed.Fields = []*discordgo.MessageEmbedField{{
+				Name:   fmt.Sprintf("Invalid count (1 to %d):", maximumBenchQueries),
+				Value:  strconv.Quote(countString),
+				Inline: true,
+			}}
+			return false
+		}
+	}
+	start := time.Now()
+	messageEmbed.Fields = make([]*discordgo.MessageEmbedField, 0, len(benchVariants))
+	for _, variant := range benchVariants {
+		result := resolveHandler.bench(variant, domain, count)
+		messageEmbed.Fields = append(messageEmbed.Fields, &discordgo.MessageEmbedField{
+			Name:  variant.name + ":",
+			Value: formatBenchResult(result, count),
+		})
+		if result.errors < count {
+			ok = true
+		}
+	}
+	messageEmbed.Description = fmt.Sprintf("Latency of %d A queries per variant for `%s`.", count, domain)
+	messageEmbed.Footer = &discordgo.MessageEmbedFooter{
+		Text: fmt.Sprintf("Sent %d queries via %s in %s.", count*len(benchVariants), resolveHandler.Upstream.String(),
+			formatMilliseconds(time.Since(start))),
+	}
+	return ok
+}
+
+// bench sends count queries of the given variant through the upstream resolver. Queries which fail or are answered with
+// SERVFAIL or REFUSED are counted as errors.
+func (resolveHandler *ResolveHandler) bench(variant benchVariant, domain string, count int) *benchResult {
+	result := &benchResult{}
+	mutex := &sync.Mutex{}
+	waitGroup := &sync.WaitGroup{}
+	semaphore := make(chan struct{}, benchConcurrency)
+	for index := 0; index < count; index++ {
+		waitGroup.Add(1)
+		semaphore <- struct{}{}
+		go func() {
+			defer func() {
+				<-semaphore
+				waitGroup.Done()
+			}()
+			message := &dns.Msg{}
+			message.SetQuestion(variant.domain(domain), dns.TypeA)
+			response, duration, err := resolveHandler.exchange(message)
+			mutex.Lock()
+			defer mutex.Unlock()
+			if err != nil || response.Rcode == dns.RcodeServerFailure || response.Rcode == dns.RcodeRefused {
+				result.errors++
+				return
+			}
+			result.durations = append(result.durations, duration)
+		}()
+	}
+	waitGroup.Wait()
+	sort.Slice(result.durations, func(i, j int) bool {
+		return result.durations[i] < result.durations[j]
+	})
+	return result
+}
+
+// formatBenchResult renders the percentiles, the error rate and the histogram of a bench result.
+func formatBenchResult(result *benchResult, count int) string {
+	buffer := &bytes.Buffer{}
+	fmt.Fprintf(buffer, "Errors: %d/%d (%.1f%%)\n", result.errors, count, float64(result.errors)*100/float64(count))
+	if len(result.durations) == 0 {
+		return strings.TrimSpace(buffer.String())
+	}
+	durations := result.durations
+	fmt.Fprintf(buffer, "min %s · median %s · p95 %s · p99 %s · max %s\n```\n",
+		formatMilliseconds(durations[0]), formatMilliseconds(benchPercentile(durations, 50)),
+		formatMilliseconds(benchPercentile(durations, 95)), formatMilliseconds(benchPercentile(durations, 99)),
+		formatMilliseconds(durations[len(durations)-1]))
+	buckets := make([]int, len(benchHistogramBuckets)+1)
+	maximumBucket := 0
+	for _, duration := range durations {
+		index := sort.Search(len(benchHistogramBuckets), func(i int) bool {
+			return duration < benchHistogramBuckets[i]
+		})
+		buckets[index]++
+		if buckets[index] > maximumBucket {
+			maximumBucket = buckets[index]
+		}
+	}
+	for index, bucket := range buckets {
+		label := ">=" + benchHistogramBuckets[len(benchHistogramBuckets)-1].String()
+		if index < len(benchHistogramBuckets) {
+			label = "<" + benchHistogramBuckets[index].String()
+		}
+		bar := strings.Repeat("#", (bucket*benchHistogramWidth+maximumBucket-1)/maximumBucket)
+		fmt.Fprintf(buffer, benchHistogramRowFormat, label, benchHistogramWidth, bar, bucket)
+	}
+	buffer.WriteString("```")
+	return buffer.String()
+}
+
+// benchPercentile returns the percentile of the sorted durations using the nearest-rank method.
+func benchPercentile(durations []time.Duration, percentile int) time.Duration {
+	rank := (percentile*len(durations) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return durations[rank-1]
+}
+
+// randomBenchLabel returns a random label which is most likely not cached by the upstream resolver.
+func randomBenchLabel() string {
+	label := make([]byte, benchRandomLabelLength)
+	if _, err := rand.Read(label); err != nil {
+		// fall back to the current time which is still unique enough to miss the cache
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(label)
+}
@@ -0,0 +1,185 @@
+package discord1111resolver
+
+import (
+	"fmt"
+	"github.com/bwmarrin/discordgo"
+	"github.com/miekg/dns"
+	"strconv"
+	"strings"
+)
+
+const (
+	// wildcardPrefix marks a domain name as wildcard certificate name (e.g. *.example.com).
+	wildcardPrefix = "*."
+	// cAAIssuerCriticalFlag is the issuer critical flag of a CAA record (RFC 8659 section 4.1).
+	cAAIssuerCriticalFlag = 128
+)
+
+// knownCAATags contains the CAA property tags which are understood by the caa command.
+var knownCAATags = map[string]bool{
+	"issue":        true,
+	"issuewild":    true,
+	"iodef":        true,
+	"contactemail": true,
+	"contactphone": true,
+}
+
+func (resolveHandler *ResolveHandler) handleCAACommand(messageCreate *discordgo.MessageCreate,
+	messageSend *messageReply, options *queryOptions, params []string) (ok bool) {
+	messageEmbed := messageSend.Embed
+	if len(params) < 1 || len(params) > 2 {
+		return false
+	}
+	wildcard := strings.HasPrefix(params[0], wildcardPrefix)
+	domain, ok := prepareDomainName(messageEmbed, strings.TrimPrefix(params[0], wildcardPrefix))
+	if !ok {
+		return false
+	}
+	var issuer string
+	if len(params) == 2 {
+		issuer = strings.ToLower(strings.TrimSuffix(params[1], "."))
+	}
+	certificateName := domain
+	if wildcard {
+		certificateName = wildcardPrefix + domain
+	}
+	messageEmbed.Description = fmt.Sprintf("CAA evaluation of `%s` (RFC 8659).", certificateName)
+	owner, records, checkedNames, err := resolveHandler.findRelevantCAARecords(domain)
+	messageEmbed.Fields = []*discordgo.MessageEmbedField{{
+		Name:  "Checked names:",
+		Value: "`" + strings.Join(checkedNames, "` → `") + "`",
+	}}
+	if err != nil {
+		// a CA has to refuse issuance if the CAA lookup fails (RFC 8659 section 3)
+		messageEmbed.Fields = append(messageEmbed.Fields, &discordgo.MessageEmbedField{
+			Name:  "The CAA lookup failed:",
+			Value: failureMarker + " " + err.Error() + "\nCertificate authorities must not issue certificates until the lookup succeeds.",
+		})
+		return false
+	}
+	if len(records) == 0 {
+		messageEmbed.Fields = append(messageEmbed.Fields, &discordgo.MessageEmbedField{
+			Name:  "Relevant CAA RRset:",
+			Value: successMarker + " None of the names has CAA records, every certificate authority may issue certificates.",
+		})
+		return true
+	}
+	lines := make([]string, len(records))
+	for index, record := range records {
+		lines[index] = fmt.Sprintf("%d %s %s", record.Flag, record.Tag, strconv.Quote(record.Value))
+	}
+	messageEmbed.Fields = append(messageEmbed.Fields, verboseSectionField(fmt.Sprintf("Relevant CAA RRset (%s):", owner), lines))
+	authorizedIssuers, unknownCriticalTag := evaluateCAARecords(records, wildcard)
+	var authorization string
+	switch {
+	case unknownCriticalTag != "":
+		ok = false
+		authorization = fmt.Sprintf("%s The property `%s` is critical but unknown, no certificate authority may issue certificates.",
+			failureMarker, unknownCriticalTag)
+	case authorizedIssuers == nil:
+		ok = true
+		authorization = successMarker + " There are no issue properties which apply, every certificate authority may issue certificates."
+		if issuer != "" {
+			authorization = fmt.Sprintf("%s `%s` may issue certificates (there are no issue properties which apply).", successMarker, issuer)
+		}
+	case len(authorizedIssuers) == 0:
+		ok = false
+		authorization = failureMarker + " No certificate authority may issue certificates."
+	case issuer == "":
+		ok = true
+		authorization = successMarker + " Only the following certificate authorities may issue certificates: `" +
+			strings.Join(authorizedIssuers, "`, `") + "`"
+	default:
+		ok = false
+		authorization = fmt.Sprintf("%s `%s` may not issue certificates, authorized are: `%s`", failureMarker, issuer,
+			strings.Join(authorizedIssuers, "`, `"))
+		for _, authorizedIssuer := range authorizedIssuers {
+			if authorizedIssuer == issuer {
+				ok = true
+				authorization = fmt.Sprintf("%s `%s` may issue certificates.", successMarker, issuer)
+				break
+			}
+		}
+	}
+	trimDiscordFieldValue(&authorization)
+	messageEmbed.Fields = append(messageEmbed.Fields, &discordgo.MessageEmbedField{
+		Name:  fmt.Sprintf("Issuance for %s:", certificateName),
+		Value: authorization,
+	})
+	var reportURLs []string
+	for _, record := range records {
+		if strings.EqualFold(record.Tag, "iodef") {
+			reportURLs = append(reportURLs, record.Value)
+		}
+	}
+	if len(reportURLs) > 0 {
+		reports := "Refused certificate requests are reported to: " + strings.Join(reportURLs, ", ")
+		trimDiscordFieldValue(&reports)
+		messageEmbed.Fields = append(messageEmbed.Fields, &discordgo.MessageEmbedField{
+			Name:  "Incident reports (iodef):",
+			Value: reports,
+		})
+	}
+	return ok
+}
+
+// findRelevantCAARecords climbs the DNS tree from the given domain towards the root until it finds a non-empty CAA
+// RRset (RFC 8659 section 3). The owner of the relevant RRset and every checked name are returned as well.
+func (resolveHandler *ResolveHandler) findRelevantCAARecords(domain string) (owner string, records []*dns.CAA, checkedNames []string, err error) {
+	for name := domain; name != ""; {
+		checkedNames = append(checkedNames, name)
+		var answers []dns.RR
+		if answers, err = resolveHandler.lookupRecords(name, dns.TypeCAA); err != nil {
+			return "", nil, checkedNames, err
+		}
+		for _, answer := range answers {
+			if caa, isCAA := answer.(*dns.CAA); isCAA {
+				records = append(records, caa)
+			}
+		}
+		if len(records) > 0 {
+			return name, records, checkedNames, nil
+		}
+		// continue with the parent domain, the root itself is not checked
+		labelIndexes := dns.Split(name)
+		if len(labelIndexes) < 2 {
+			break
+		}
+		name = name[labelIndexes[1]:]
+	}
+	return "", nil, checkedNames, nil
+}
+
+// evaluateCAARecords returns the certificate authorities which are authorized by the relevant CAA RRset. The issuewild
+// properties take precedence over the issue properties for wildcard names. If no issue property applies, nil is
+// returned (every CA may issue); an empty slice means that no CA may issue. If the RRset contains a critical property
+// which is unknown, it is returned as well.
+func evaluateCAARecords(records []*dns.CAA, wildcard bool) (authorizedIssuers []string, unknownCriticalTag string) {
+	tag := "issue"
+	if wildcard {
+		for _, record := range records {
+			if strings.EqualFold(record.Tag, "issuewild") {
+				tag = "issuewild"
+				break
+			}
+		}
+	}
+	for _, record := range records {
+		if !knownCAATags[strings.ToLower(record.Tag)] && record.Flag&cAAIssuerCriticalFlag != 0 {
+			unknownCriticalTag = record.Tag
+		}
+		if !strings.EqualFold(record.Tag, tag) {
+			continue
+		}
+		if authorizedIssuers == nil {
+			authorizedIssuers = []string{}
+		}
+		// the issuer domain name may be followed by parameters (e.g. "ca.example; account=123"), an empty issuer
+		// domain name authorizes no CA at all
+		issuer := strings.ToLower(strings.TrimSpace(strings.SplitN(record.Value, ";", 2)[0]))
+		if issuer != "" {
+			authorizedIssuers = append(authorizedIssuers, issuer)
+		}
+	}
+	return authorizedIssuers, unknownCriticalTag
+}
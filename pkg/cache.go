@@ -0,0 +1,325 @@
+package discord1111resolver
+
+import (
+	"container/list"
+	"fmt"
+	"github.com/miekg/dns"
+	"github.com/sirupsen/logrus"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultCacheSize is the default maximum number of responses kept in the response cache.
+	DefaultCacheSize = 1000
+	// maximumCacheTTL caps the time a response is cached, regardless of its TTLs.
+	maximumCacheTTL = 24 * time.Hour
+	// DefaultStaleWindow is the default time expired responses are kept to answer queries while the upstream resolver
+	// cannot be reached (RFC 8767 suggests one to three days).
+	DefaultStaleWindow = 24 * time.Hour
+	// staleAnswerTTL is the TTL of stale responses (RFC 8767 section 4).
+	staleAnswerTTL = 30
+	// staleRefreshInterval is the interval in which the upstream is queried to refresh a stale response.
+	staleRefreshInterval = 30 * time.Second
+	// cachedResponseFormat is prepended to the footer of a response which was answered from the cache.
+	cachedResponseFormat = "Cached, TTL remaining %ds. "
+	// staleResponseFooterFormat is prepended to the footer of a stale response.
+	staleResponseFooterFormat = "Stale, cached %v ago. "
+	// staleResponseFormat describes why a stale response is shown.
+	staleResponseFormat = ":warning: **Stale answer:** the upstream resolver could not be reached (%s). This answer " +
+		"was cached %v ago and has expired; it is refreshed in the background (RFC 8767)."
+)
+
+// cacheStatus describes whether a response was answered from the cache.
+type cacheStatus struct {
+	// remaining is the time until a cached response expires. It is zero if the response was not answered from the
+	// cache or is stale.
+	remaining time.Duration
+	// staleAge is the age of a stale response which was served because the upstream could not be reached. It is zero
+	// if the response is not stale.
+	staleAge time.Duration
+	// staleReason is the error of the upstream exchange which made the cache serve a stale response.
+	staleReason error
+}
+
+// responseCache is a size bound LRU cache of DNS responses which keeps every response until its minimum TTL expires.
+type responseCache struct {
+	sync.Mutex
+	// capacity is the maximum number of cached responses.
+	capacity int
+	// entries contains the list element of every cached response indexed by its cache key.
+	entries map[string]*list.Element
+	// recentlyUsed contains the cache entries ordered from the most to the least recently used one.
+	recentlyUsed *list.List
+	// staleWindow is the time expired responses are kept to be served while the upstream cannot be reached.
+	staleWindow time.Duration
+	// refreshing contains the keys of the stale responses which are currently refreshed in the background.
+	refreshing map[string]bool
+}
+
+// cacheEntry is a single cached response.
+type cacheEntry struct {
+	// key is the cache key of the question (see responseCacheKey).
+	key string
+	// response is the response as it was returned by the upstream.
+	response *dns.Msg
+	// duration is the round trip time of the upstream query.
+	duration time.Duration
+	// stored is the time the response was cached.
+	stored time.Time
+	// expires is the time the minimum TTL of the response expires.
+	expires time.Time
+}
+
+// newResponseCache creates a response cache which holds up to capacity responses and keeps expired responses for the
+// given stale window.
+func newResponseCache(capacity int, staleWindow time.Duration) *responseCache {
+	return &responseCache{
+		capacity:     capacity,
+		entries:      make(map[string]*list.Element),
+		recentlyUsed: list.New(),
+		staleWindow:  staleWindow,
+		refreshing:   make(map[string]bool),
+	}
+}
+
+// get returns a copy of the cached response whose TTLs are decremented by the time it spent in the cache together with
+// the time until it expires. Expired responses are removed once they are older than the stale window.
+func (cache *responseCache) get(key string, now time.Time) (response *dns.Msg, duration time.Duration, remaining time.Duration, found bool) {
+	cache.Lock()
+	defer cache.Unlock()
+	element, found := cache.entries[key]
+	if !found {
+		return nil, 0, 0, false
+	}
+	entry := element.Value.(*cacheEntry)
+	if !now.Before(entry.expires) {
+		if !now.Before(entry.expires.Add(cache.staleWindow)) {
+			cache.recentlyUsed.Remove(element)
+			delete(cache.entries, key)
+		}
+		return nil, 0, 0, false
+	}
+	cache.recentlyUsed.MoveToFront(element)
+	elapsed := uint32(now.Sub(entry.stored) / time.Second)
+	return copyWithTTLs(entry.response, func(ttl uint32) uint32 {
+		if ttl > elapsed {
+			return ttl - elapsed
+		}
+		return 0
+	}), entry.duration, entry.expires.Sub(now), true
+}
+
+// stale returns a copy of the expired response with the stale TTL if it is still within the stale window together with
+// its age.
+func (cache *responseCache) stale(key string, now time.Time) (response *dns.Msg, duration time.Duration, age time.Duration, found bool) {
+	cache.Lock()
+	defer cache.Unlock()
+	entry, found := cache.staleEntry(key, now)
+	if !found {
+		return nil, 0, 0, false
+	}
+	return copyWithTTLs(entry.response, func(ttl uint32) uint32 {
+		return staleAnswerTTL
+	}), entry.duration, now.Sub(entry.stored), true
+}
+
+// staleEntry returns the entry if it has expired but is still within the stale window. The cache has to be locked.
+func (cache *responseCache) staleEntry(key string, now time.Time) (entry *cacheEntry, found bool) {
+	element, found := cache.entries[key]
+	if !found {
+		return nil, false
+	}
+	entry = element.Value.(*cacheEntry)
+	if now.Before(entry.expires) || !now.Before(entry.expires.Add(cache.staleWindow)) {
+		return nil, false
+	}
+	return entry, true
+}
+
+// startRefresh marks the stale response as being refreshed and returns false if it is already refreshed.
+func (cache *responseCache) startRefresh(key string) bool {
+	cache.Lock()
+	defer cache.Unlock()
+	if cache.refreshing[key] {
+		return false
+	}
+	cache.refreshing[key] = true
+	return true
+}
+
+// stopRefresh removes the refresh mark of the stale response.
+func (cache *responseCache) stopRefresh(key string) {
+	cache.Lock()
+	defer cache.Unlock()
+	delete(cache.refreshing, key)
+}
+
+// isStale checks whether the response has expired but is still within the stale window.
+func (cache *responseCache) isStale(key string, now time.Time) bool {
+	cache.Lock()
+	defer cache.Unlock()
+	_, found := cache.staleEntry(key, now)
+	return found
+}
+
+// set caches the response if it is cacheable and evicts the least recently used responses if the cache is full.
+func (cache *responseCache) set(key string, response *dns.Msg, duration time.Duration, now time.Time) {
+	ttl, cacheable := responseCacheTTL(response)
+	if !cacheable {
+		return
+	}
+	entry := &cacheEntry{
+		key:      key,
+		response: response.Copy(),
+		duration: duration,
+		stored:   now,
+		expires:  now.Add(ttl),
+	}
+	cache.Lock()
+	defer cache.Unlock()
+	if element, found := cache.entries[key]; found {
+		element.Value = entry
+		cache.recentlyUsed.MoveToFront(element)
+		return
+	}
+	cache.entries[key] = cache.recentlyUsed.PushFront(entry)
+	for cache.recentlyUsed.Len() > cache.capacity {
+		oldest := cache.recentlyUsed.Back()
+		cache.recentlyUsed.Remove(oldest)
+		delete(cache.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// cachedExchange answers the message from the response cache if possible and otherwise sends it to the upstream and
+// caches the response. Identical queries which are in flight at the same time are collapsed into a single exchange.
+// If the upstream cannot be reached, an expired response within the stale window is served and refreshed in the
+// background. The key has to contain everything besides the question which changes the response.
+func (resolveHandler *ResolveHandler) cachedExchange(upstream Upstream, message *dns.Msg, key string) (response *dns.Msg, duration time.Duration, status cacheStatus, err error) {
+	cache := resolveHandler.cache
+	if cache != nil {
+		if response, duration, remaining, found := cache.get(key, time.Now()); found {
+			response.Id = message.Id
+			return response, duration, cacheStatus{remaining: remaining}, nil
+		}
+	}
+	response, duration, shared, err := resolveHandler.inflight.do(key, func() (*dns.Msg, time.Duration, error) {
+		response, duration, err := upstream.Exchange(message)
+		if err == nil && cache != nil {
+			cache.set(key, response, duration, time.Now())
+		}
+		return response, duration, err
+	})
+	if err != nil {
+		// a pin mismatch is reported instead of being hidden behind a stale response
+		if _, isPinMismatch := err.(*SPKIPinMismatchError); isPinMismatch || cache == nil {
+			return nil, 0, cacheStatus{}, err
+		}
+		staleResponse, staleDuration, age, found := cache.stale(key, time.Now())
+		if !found {
+			return nil, 0, cacheStatus{}, err
+		}
+		logrus.WithError(err).WithField("age", age).Warn("serving stale response because the upstream could not be reached")
+		resolveHandler.refreshStaleResponse(upstream, message, key)
+		staleResponse.Id = message.Id
+		return staleResponse, staleDuration, cacheStatus{staleAge: age, staleReason: err}, nil
+	}
+	if shared {
+		response.Id = message.Id
+	}
+	return response, duration, cacheStatus{}, nil
+}
+
+// refreshStaleResponse queries the upstream in the background until the stale response was refreshed or has left the
+// stale window. Only one refresh per response runs at a time.
+func (resolveHandler *ResolveHandler) refreshStaleResponse(upstream Upstream, message *dns.Msg, key string) {
+	cache := resolveHandler.cache
+	if !cache.startRefresh(key) {
+		return
+	}
+	go func() {
+		defer cache.stopRefresh(key)
+		ticker := time.NewTicker(staleRefreshInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			// another query may have refreshed the response in the meantime
+			if !cache.isStale(key, time.Now()) {
+				return
+			}
+			refreshMessage := message.Copy()
+			refreshMessage.Id = dns.Id()
+			response, duration, err := upstream.Exchange(refreshMessage)
+			if err != nil {
+				logrus.WithError(err).WithField("upstream", upstream.String()).Debug("could not refresh stale response")
+				continue
+			}
+			cache.set(key, response, duration, time.Now())
+			logrus.WithField("upstream", upstream.String()).Info("refreshed stale response")
+			return
+		}
+	}()
+}
+
+// responseCacheKey builds the cache key of a question. Besides the name, type and class it contains the upstream (which
+// differs per resolver profile) and the query options which change the response.
+func responseCacheKey(message *dns.Msg, upstream Upstream, options *queryOptions) string {
+	question := message.Question[0]
+	return fmt.Sprintf("%s|%d|%d|%s|%t|%s", strings.ToLower(question.Name), question.Qtype, question.Qclass,
+		upstream.String(), options.dnssec, strings.ToLower(options.geo))
+}
+
+// responseCacheTTL returns how long the response may be cached: the minimum TTL of the answer section for positive
+// responses and the minimum of the SOA TTL and the SOA minimum field for negative responses (RFC 2308 section 5).
+// Truncated responses and errors other than NXDOMAIN are not cached.
+func responseCacheTTL(response *dns.Msg) (ttl time.Duration, cacheable bool) {
+	if response.Truncated || (response.Rcode != dns.RcodeSuccess && response.Rcode != dns.RcodeNameError) {
+		return 0, false
+	}
+	var minimumTTL uint32
+	found := false
+	if response.Rcode == dns.RcodeSuccess && len(response.Answer) > 0 {
+		for _, record := range response.Answer {
+			if !found || record.Header().Ttl < minimumTTL {
+				minimumTTL, found = record.Header().Ttl, true
+			}
+		}
+	} else {
+		for _, record := range response.Ns {
+			soa, isSOA := record.(*dns.SOA)
+			if !isSOA {
+				continue
+			}
+			minimumTTL, found = soa.Hdr.Ttl, true
+			if soa.Minttl < minimumTTL {
+				minimumTTL = soa.Minttl
+			}
+			break
+		}
+	}
+	// negative responses without a SOA record must not be cached (RFC 2308 section 5)
+	if !found || minimumTTL == 0 {
+		return 0, false
+	}
+	ttl = time.Duration(minimumTTL) * time.Second
+	if ttl > maximumCacheTTL {
+		ttl = maximumCacheTTL
+	}
+	return ttl, true
+}
+
+// copyWithTTLs returns a copy of the response whose TTLs are replaced by the result of the adjust function.
+func copyWithTTLs(response *dns.Msg, adjust func(ttl uint32) uint32) *dns.Msg {
+	response = response.Copy()
+	for _, section := range [][]dns.RR{response.Answer, response.Ns, response.Extra} {
+		for _, record := range section {
+			header := record.Header()
+			// the TTL field of the OPT pseudo-record contains flags
+			if header.Rrtype == dns.TypeOPT {
+				continue
+			}
+			header.Ttl = adjust(header.Ttl)
+		}
+	}
+	return response
+}
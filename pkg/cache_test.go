@@ -0,0 +1,198 @@
+package discord1111resolver
+
+import (
+	"github.com/miekg/dns"
+	"testing"
+	"time"
+)
+
+// newTestResponse builds a response with the given response code and answer and authority records.
+func newTestResponse(t *testing.T, rcode int, answer []string, authority []string) *dns.Msg {
+	response := &dns.Msg{}
+	response.SetQuestion("www.example.com.", dns.TypeA)
+	response.Response = true
+	response.Rcode = rcode
+	for _, records := range []struct {
+		section *[]dns.RR
+		lines   []string
+	}{{&response.Answer, answer}, {&response.Ns, authority}} {
+		for _, line := range records.lines {
+			record, err := dns.NewRR(line)
+			if err != nil {
+				t.Fatalf("could not parse %q: %v", line, err)
+			}
+			*records.section = append(*records.section, record)
+		}
+	}
+	return response
+}
+
+func TestResponseCacheTTL(t *testing.T) {
+	const soa = "example.com. 3600 IN SOA ns.example.com. hostmaster.example.com. 1 7200 900 1209600 300"
+	tests := []struct {
+		name      string
+		rcode     int
+		answer    []string
+		authority []string
+		truncated bool
+		ttl       time.Duration
+		cacheable bool
+	}{
+		{
+			name:      "minimum answer TTL",
+			rcode:     dns.RcodeSuccess,
+			answer:    []string{"www.example.com. 300 IN CNAME web.example.com.", "web.example.com. 60 IN A 192.0.2.1"},
+			ttl:       60 * time.Second,
+			cacheable: true,
+		},
+		{
+			name:      "NXDOMAIN with SOA uses the SOA minimum",
+			rcode:     dns.RcodeNameError,
+			authority: []string{soa},
+			ttl:       300 * time.Second,
+			cacheable: true,
+		},
+		{
+			name:      "NXDOMAIN with SOA uses the SOA TTL if it is lower",
+			rcode:     dns.RcodeNameError,
+			authority: []string{"example.com. 120 IN SOA ns.example.com. hostmaster.example.com. 1 7200 900 1209600 300"},
+			ttl:       120 * time.Second,
+			cacheable: true,
+		},
+		{
+			name:      "NODATA with SOA",
+			rcode:     dns.RcodeSuccess,
+			authority: []string{soa},
+			ttl:       300 * time.Second,
+			cacheable: true,
+		},
+		{
+			name:      "negative answer without SOA",
+			rcode:     dns.RcodeNameError,
+			authority: []string{"example.com. 3600 IN NS ns.example.com."},
+			cacheable: false,
+		},
+		{
+			name:      "NODATA without SOA",
+			rcode:     dns.RcodeSuccess,
+			cacheable: false,
+		},
+		{
+			name:      "zero TTL",
+			rcode:     dns.RcodeSuccess,
+			answer:    []string{"www.example.com. 0 IN A 192.0.2.1"},
+			cacheable: false,
+		},
+		{
+			name:      "maximum TTL",
+			rcode:     dns.RcodeSuccess,
+			answer:    []string{"www.example.com. 604800 IN A 192.0.2.1"},
+			ttl:       maximumCacheTTL,
+			cacheable: true,
+		},
+		{
+			name:      "server failure",
+			rcode:     dns.RcodeServerFailure,
+			cacheable: false,
+		},
+		{
+			name:      "truncated",
+			rcode:     dns.RcodeSuccess,
+			answer:    []string{"www.example.com. 300 IN A 192.0.2.1"},
+			truncated: true,
+			cacheable: false,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			response := newTestResponse(t, test.rcode, test.answer, test.authority)
+			response.Truncated = test.truncated
+			ttl, cacheable := responseCacheTTL(response)
+			if cacheable != test.cacheable || ttl != test.ttl {
+				t.Errorf("expected (%v, %t), got (%v, %t)", test.ttl, test.cacheable, ttl, cacheable)
+			}
+		})
+	}
+}
+
+func TestCopyWithTTLs(t *testing.T) {
+	response := newTestResponse(t, dns.RcodeSuccess, []string{"www.example.com. 300 IN A 192.0.2.1"},
+		[]string{"example.com. 3600 IN NS ns.example.com."})
+	// the TTL field of the OPT record contains the extended response code, the version and the DO bit
+	response.SetEdns0(dns.DefaultMsgSize, true)
+	optTTL := response.IsEdns0().Hdr.Ttl
+	decremented := copyWithTTLs(response, func(ttl uint32) uint32 {
+		return ttl - 100
+	})
+	if ttl := decremented.Answer[0].Header().Ttl; ttl != 200 {
+		t.Errorf("expected the answer TTL 200, got %d", ttl)
+	}
+	if ttl := decremented.Ns[0].Header().Ttl; ttl != 3500 {
+		t.Errorf("expected the authority TTL 3500, got %d", ttl)
+	}
+	if ttl := decremented.IsEdns0().Hdr.Ttl; ttl != optTTL {
+		t.Errorf("expected the OPT TTL %d to be left unchanged, got %d", optTTL, ttl)
+	}
+	if ttl := response.Answer[0].Header().Ttl; ttl != 300 {
+		t.Errorf("the original response was modified, got the answer TTL %d", ttl)
+	}
+}
+
+func TestResponseCacheGet(t *testing.T) {
+	cache := newResponseCache(10, 0)
+	now := time.Now()
+	cache.set("key", newTestResponse(t, dns.RcodeSuccess, []string{"www.example.com. 300 IN A 192.0.2.1"}, nil),
+		time.Millisecond, now)
+	tests := []struct {
+		name      string
+		age       time.Duration
+		found     bool
+		ttl       uint32
+		remaining time.Duration
+	}{
+		{name: "fresh", age: 0, found: true, ttl: 300, remaining: 300 * time.Second},
+		{name: "decremented", age: 100 * time.Second, found: true, ttl: 200, remaining: 200 * time.Second},
+		{name: "expired", age: 300 * time.Second, found: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			response, _, remaining, found := cache.get("key", now.Add(test.age))
+			if found != test.found {
+				t.Fatalf("expected found to be %t", test.found)
+			}
+			if !found {
+				return
+			}
+			if ttl := response.Answer[0].Header().Ttl; ttl != test.ttl {
+				t.Errorf("expected the TTL %d, got %d", test.ttl, ttl)
+			}
+			if remaining != test.remaining {
+				t.Errorf("expected the remaining time %v, got %v", test.remaining, remaining)
+			}
+		})
+	}
+	if _, found := cache.entries["key"]; found {
+		t.Error("the expired response was not removed")
+	}
+}
+
+func TestResponseCacheEviction(t *testing.T) {
+	cache := newResponseCache(2, 0)
+	now := time.Now()
+	response := newTestResponse(t, dns.RcodeSuccess, []string{"www.example.com. 300 IN A 192.0.2.1"}, nil)
+	cache.set("first", response, 0, now)
+	cache.set("second", response, 0, now)
+	// reading the first response makes the second one the least recently used
+	if _, _, _, found := cache.get("first", now); !found {
+		t.Fatal("the first response is missing")
+	}
+	cache.set("third", response, 0, now)
+	for key, expected := range map[string]bool{"first": true, "second": false, "third": true} {
+		if _, _, _, found := cache.get(key, now); found != expected {
+			t.Errorf("expected %s to be cached: %t", key, expected)
+		}
+	}
+	if length := cache.recentlyUsed.Len(); length != 2 {
+		t.Errorf("expected 2 cached responses, got %d", length)
+	}
+}
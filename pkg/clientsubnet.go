@@ -0,0 +1,100 @@
+package discord1111resolver
+
+import (
+	"fmt"
+	"github.com/bwmarrin/discordgo"
+	"github.com/miekg/dns"
+	"net"
+	"strconv"
+	"strings"
+)
+
+const (
+	// DefaultGeoPresets contains the default client subnet presets which can be selected with the --geo option.
+	DefaultGeoPresets = "eu=88.198.0.0/24,us-east=3.208.0.0/24,asia=13.112.0.0/24"
+	// clientSubnetFormat is used to describe the client subnet which was sent along with the query.
+	clientSubnetFormat = "`%s` (%s) - scope prefix `/%d`"
+	// clientSubnetScopeZeroWarning is appended if the upstream did not tailor the answer to the client subnet.
+	clientSubnetScopeZeroWarning = "\n:warning: The upstream returned scope 0: the answer is valid for every client " +
+		"(the resolver or the zone ignores EDNS Client Subnet)."
+)
+
+// ParseGeoPresets parses comma separated client subnet presets with the format name=prefix (e.g. eu=88.198.0.0/24).
+func ParseGeoPresets(value string) (map[string]*net.IPNet, error) {
+	presets := make(map[string]*net.IPNet)
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		nameAndPrefix := strings.SplitN(entry, "=", 2)
+		if len(nameAndPrefix) != 2 {
+			return nil, fmt.Errorf("invalid client subnet preset %q (expected name=prefix)", entry)
+		}
+		_, prefix, err := net.ParseCIDR(nameAndPrefix[1])
+		if err != nil {
+			return nil, err
+		}
+		presets[strings.ToLower(nameAndPrefix[0])] = prefix
+	}
+	return presets, nil
+}
+
+// resolveClientSubnet converts the value of the --geo option (a preset name or a prefix) to a prefix.
+func (resolveHandler *ResolveHandler) resolveClientSubnet(messageEmbed *discordgo.MessageEmbed, geo string) (prefix *net.IPNet, presetName string, ok bool) {
+	if prefix, ok = resolveHandler.GeoPresets[strings.ToLower(geo)]; ok {
+		return prefix, strings.ToLower(geo), true
+	}
+	if _, prefix, err := net.ParseCIDR(geo); err == nil {
+		return prefix, "custom prefix", true
+	}
+	presetNames := make([]string, 0, len(resolveHandler.GeoPresets))
+	for name := range resolveHandler.GeoPresets {
+		presetNames = append(presetNames, name)
+	}
+	trimDiscordFieldValue(&geo)
+	messageEmbed.Fields = []*discordgo.MessageEmbedField{{
+		Name:   "Invalid client subnet (expected a prefix or one of " + strings.Join(presetNames, ", ") + "):",
+		Value:  strconv.Quote(geo),
+		Inline: true,
+	}}
+	return nil, "", false
+}
+
+// setClientSubnet attaches an EDNS0 Client Subnet option (RFC 7871) with the given prefix to the message.
+func setClientSubnet(message *dns.Msg, prefix *net.IPNet) {
+	option := message.IsEdns0()
+	if option == nil {
+		message.SetEdns0(dns.DefaultMsgSize, false)
+		option = message.IsEdns0()
+	}
+	sourceNetmask, _ := prefix.Mask.Size()
+	subnet := &dns.EDNS0_SUBNET{
+		Code:          dns.EDNS0SUBNET,
+		Family:        1,
+		SourceNetmask: uint8(sourceNetmask),
+		Address:       prefix.IP,
+	}
+	if prefix.IP.To4() == nil {
+		subnet.Family = 2
+	}
+	option.Option = append(option.Option, subnet)
+}
+
+// clientSubnetField describes the client subnet of the query and the scope prefix returned by the upstream.
+func clientSubnetField(response *dns.Msg, prefix *net.IPNet, presetName string) *discordgo.MessageEmbedField {
+	value := fmt.Sprintf("`%s` (%s) - the upstream did not return a client subnet option", prefix.String(), presetName)
+	if option := response.IsEdns0(); option != nil {
+		for _, ednsOption := range option.Option {
+			if subnet, ok := ednsOption.(*dns.EDNS0_SUBNET); ok {
+				value = fmt.Sprintf(clientSubnetFormat, prefix.String(), presetName, subnet.SourceScope)
+				if subnet.SourceScope == 0 {
+					value += clientSubnetScopeZeroWarning
+				}
+			}
+		}
+	}
+	return &discordgo.MessageEmbedField{
+		Name:  "EDNS Client Subnet:",
+		Value: value,
+	}
+}
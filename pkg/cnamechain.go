@@ -0,0 +1,123 @@
+package discord1111resolver
+
+import (
+	"fmt"
+	"github.com/miekg/dns"
+	"strings"
+	"time"
+)
+
+const (
+	// maximumCNAMEChainLength is the maximum number of CNAME records which are followed for a single query.
+	maximumCNAMEChainLength = 8
+	// cNAMEChainSeparator separates the names of a rendered CNAME chain.
+	cNAMEChainSeparator = " → "
+)
+
+// cNAMEChain contains the result of following the CNAME records of a response.
+type cNAMEChain struct {
+	// names contains the queried name followed by every CNAME target.
+	names []string
+	// answers contains the answer section of the response and of every additional query which was necessary to
+	// complete the chain.
+	answers []dns.RR
+	// problem describes why the chain could not be followed completely (e.g. a loop).
+	problem string
+}
+
+// followCNAMEChain follows the CNAME records of the response starting at the queried name. If the upstream left the
+// chain incomplete (the last target has no records of the queried type in the response), the target is queried with
+// a copy of the original message.
+func followCNAMEChain(exchange func(message *dns.Msg) (*dns.Msg, time.Duration, error), message *dns.Msg, response *dns.Msg) *cNAMEChain {
+	question := message.Question[0]
+	name := question.Name
+	chain := &cNAMEChain{
+		names:   []string{name},
+		answers: append([]dns.RR{}, response.Answer...),
+	}
+	visited := map[string]bool{strings.ToLower(name): true}
+	queries := 0
+	for {
+		if target, found := findCNAMETarget(chain.answers, name); found {
+			if question.Qtype == dns.TypeCNAME && strings.EqualFold(name, question.Name) {
+				return chain
+			}
+			if visited[strings.ToLower(target)] {
+				chain.problem = fmt.Sprintf("CNAME loop detected: `%s` points back to `%s`.", name, target)
+				return chain
+			}
+			if len(chain.names) > maximumCNAMEChainLength {
+				chain.problem = fmt.Sprintf("The CNAME chain is longer than %d names and was not followed any further.",
+					maximumCNAMEChainLength)
+				return chain
+			}
+			visited[strings.ToLower(target)] = true
+			chain.names = append(chain.names, target)
+			name = target
+			continue
+		}
+		// the chain ends here if the records of the queried type are present or if there is no chain at all
+		if len(chain.names) == 1 || containsOwnedRRType(chain.answers, name, question.Qtype) ||
+			queries >= maximumCNAMEChainLength {
+			return chain
+		}
+		// the upstream left the chain incomplete, query the last target
+		queries++
+		targetMessage := message.Copy()
+		targetMessage.Id = dns.Id()
+		targetMessage.Question[0].Name = name
+		targetResponse, _, err := exchange(targetMessage)
+		if err != nil {
+			chain.problem = fmt.Sprintf("Could not follow the CNAME chain to `%s`: %s", name, err.Error())
+			return chain
+		}
+		if errorMessage, ok := validateDNSResponseCode(targetResponse.Rcode); !ok {
+			chain.problem = fmt.Sprintf("The CNAME target `%s` could not be resolved: %s", name, errorMessage)
+			return chain
+		}
+		if len(targetResponse.Answer) == 0 {
+			return chain
+		}
+		chain.answers = append(chain.answers, targetResponse.Answer...)
+	}
+}
+
+// render returns the chain of names followed by the values of the final records, e.g.
+// "www.example.com. → cdn.example.net. → 192.0.2.1".
+func (chain *cNAMEChain) render(recordType *dNSRecordType) string {
+	parts := make([]string, 0, len(chain.names)+1)
+	for _, name := range chain.names {
+		parts = append(parts, fmt.Sprintf(dNSAnswerCodeFormat, name))
+	}
+	lastName := chain.names[len(chain.names)-1]
+	var values []string
+	for _, answer := range chain.answers {
+		if answer.Header().Rrtype == recordType.messageType && strings.EqualFold(answer.Header().Name, lastName) {
+			values = append(values, recordType.format(answer))
+		}
+	}
+	if len(values) > 0 {
+		parts = append(parts, strings.Join(values, ", "))
+	}
+	return strings.Join(parts, cNAMEChainSeparator)
+}
+
+// findCNAMETarget returns the target of the CNAME record owned by the given name.
+func findCNAMETarget(records []dns.RR, name string) (target string, found bool) {
+	for _, record := range records {
+		if cname, ok := record.(*dns.CNAME); ok && strings.EqualFold(cname.Hdr.Name, name) {
+			return cname.Target, true
+		}
+	}
+	return "", false
+}
+
+// containsOwnedRRType checks whether any of the given records has the given owner name and type.
+func containsOwnedRRType(records []dns.RR, name string, rrType uint16) bool {
+	for _, record := range records {
+		if record.Header().Rrtype == rrType && strings.EqualFold(record.Header().Name, name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,139 @@
+package discord1111resolver
+
+import (
+	"fmt"
+	"github.com/bwmarrin/discordgo"
+	"strings"
+)
+
+// commandSyntaxFormat is used to hand out the syntax of a single bot command to the Discord users.
+const commandSyntaxFormat = "@%s %s"
+
+// botCommand describes a command which can be executed by Discord users in addition to plain DNS queries.
+type botCommand struct {
+	// name is the name of the command which is used as the first parameter (e.g. trace).
+	name string
+	// syntax describes the parameters of the command including its name.
+	syntax string
+	// help is a short description of the command which is shown to Discord users.
+	help string
+	// handle executes the command with all parameters after the command name. It returns whether the execution was a
+	// success and fills the message embed (and attachments) accordingly.
+	handle func(resolveHandler *ResolveHandler, messageCreate *discordgo.MessageCreate,
+		messageSend *messageReply, options *queryOptions, params []string) (ok bool)
+}
+
+// botCommands contains all supported bot commands in the order they are presented to Discord users.
+var botCommands = []*botCommand{
+	{
+		name:   "dig",
+		syntax: "dig <type> <domain>",
+		help:   "shows the complete response with header flags, every section and TTLs (same as +verbose)",
+		handle: (*ResolveHandler).handleDigCommand,
+	},
+	{
+		name:   "trace",
+		syntax: "trace [type] <domain>",
+		help:   "follows the delegation from the root name servers step by step",
+		handle: (*ResolveHandler).handleTraceCommand,
+	},
+	{
+		name:   "compare",
+		syntax: "compare <type> <domain>",
+		help:   "sends the same question to several public resolvers and shows the differences",
+		handle: (*ResolveHandler).handleCompareCommand,
+	},
+	{
+		name:   "validate",
+		syntax: "validate [origin] (with an attached zone file)",
+		help:   "checks an attached zone file for syntax errors, missing SOA/NS records, out-of-zone data, CNAME conflicts and duplicates",
+		handle: (*ResolveHandler).handleValidateCommand,
+	},
+	{
+		name:   "mail",
+		syntax: "mail <domain> [dkim-selector...]",
+		help:   "checks MX, SPF (including the DNS lookup limit), DMARC, DKIM, MTA-STS and SMTP TLS reporting records",
+		handle: (*ResolveHandler).handleMailCommand,
+	},
+	{
+		name:   "dane",
+		syntax: "dane <port> <host> (optionally with an attached PEM certificate chain)",
+		help:   "checks the TLSA records of a TCP service, whether they are DNSSEC-authenticated and which attached certificate they match",
+		handle: (*ResolveHandler).handleDANECommand,
+	},
+	{
+		name:   "caa",
+		syntax: "caa <domain> [ca-domain]",
+		help:   "finds the relevant CAA records (RFC 8659) and checks which certificate authorities may issue certificates (`CAA <domain>` in upper case queries the records)",
+		handle: (*ResolveHandler).handleCAACommand,
+	},
+	{
+		name:   "health",
+		syntax: "health <zone>",
+		help:   "queries every authoritative name server address and reports lame delegations, serial mismatches, missing glue and NS set differences",
+		handle: (*ResolveHandler).handleHealthCommand,
+	},
+	{
+		name:   "propagation",
+		syntax: "propagation <type> <domain> [expected-value] [every <interval>]",
+		help:   "shows which authoritative name servers and public resolvers already return the expected value and refreshes until all are up to date",
+		handle: (*ResolveHandler).handlePropagationCommand,
+	},
+	{
+		name:   "watch",
+		syntax: "watch <type> <domain> every <interval> | watch list",
+		help:   "polls a record on a schedule and notifies this channel when the answer changes, or lists the subscriptions of this channel",
+		handle: (*ResolveHandler).handleWatchCommand,
+	},
+	{
+		name:   "unwatch",
+		syntax: "unwatch <id>",
+		help:   "removes a watch subscription of this channel",
+		handle: (*ResolveHandler).handleUnwatchCommand,
+	},
+	{
+		name:   "bench",
+		syntax: "bench <domain> [count]",
+		help:   "measures the latency of cached and uncached queries through the upstream resolver and shows percentiles, the error rate and a histogram",
+		handle: (*ResolveHandler).handleBenchCommand,
+	},
+}
+
+// botCommandsByName contains all supported bot commands indexed by their name.
+var botCommandsByName = make(map[string]*botCommand, len(botCommands))
+
+func init() {
+	for _, command := range botCommands {
+		botCommandsByName[command.name] = command
+	}
+}
+
+// botCommandsHelp returns a short help line for every supported command and its description.
+func botCommandsHelp() []string {
+	lines := make([]string, len(botCommands))
+	for index, command := range botCommands {
+		lines[index] = fmt.Sprintf("`%s` - %s", command.syntax, command.help)
+	}
+	return lines
+}
+
+// handleCommand executes the bot command with the given name. If there is no such command, handled is false. Commands
+// which are named like a record type (caa) are only recognized in lower case, otherwise the record type is queried.
+func (resolveHandler *ResolveHandler) handleCommand(messageCreate *discordgo.MessageCreate,
+	messageSend *messageReply, options *queryOptions, params []string) (handled bool, ok bool) {
+	name := strings.ToLower(params[0])
+	command, found := botCommandsByName[name]
+	if !found {
+		return false, false
+	}
+	if _, isRecordType := validateDNSMessageType(name); isRecordType && params[0] != name {
+		return false, false
+	}
+	ok = command.handle(resolveHandler, messageCreate, messageSend, options, params[1:])
+	if !ok && messageSend.Embed.Footer == nil {
+		messageSend.Embed.Footer = &discordgo.MessageEmbedFooter{
+			Text: fmt.Sprintf(commandSyntaxFormat, resolveHandler.DiscordBotUser.Username, command.syntax),
+		}
+	}
+	return true, ok
+}
@@ -0,0 +1,170 @@
+package discord1111resolver
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/bwmarrin/discordgo"
+	"github.com/miekg/dns"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// compareTableRowFormat is used to render a single row of the comparison table.
+	compareTableRowFormat = "%-22s %-9s %-8s %s\n"
+	// compareAnswerSetNames contains the names of the distinct answer sets.
+	compareAnswerSetNames = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+)
+
+// publicResolver describes a public recursive resolver by its address and operator.
+type publicResolver struct {
+	address  string
+	operator string
+}
+
+// comparisonResolvers contains the public resolvers which are always queried by the compare command.
+var comparisonResolvers = []publicResolver{
+	{address: "1.1.1.1", operator: "Cloudflare"},
+	{address: "1.0.0.1", operator: "Cloudflare"},
+	{address: "8.8.8.8", operator: "Google"},
+	{address: "9.9.9.9", operator: "Quad9"},
+}
+
+// comparisonResult contains the response of a single resolver.
+type comparisonResult struct {
+	resolver  publicResolver
+	response  *dns.Msg
+	duration  time.Duration
+	err       error
+	answerSet string
+}
+
+func (resolveHandler *ResolveHandler) handleCompareCommand(messageCreate *discordgo.MessageCreate,
+	messageSend *messageReply, options *queryOptions, params []string) (ok bool) {
+	messageEmbed := messageSend.Embed
+	if len(params) != 2 {
+		return false
+	}
+	recordType, ok := validateDNSMessageTypeParam(messageEmbed, params[0])
+	if !ok {
+		return false
+	}
+	domain, ok := prepareDomainName(messageEmbed, params[1])
+	if !ok {
+		return false
+	}
+	results := resolveHandler.compare(domain, recordType.messageType)
+	// assign a name to every distinct answer set
+	answerSetNames := make(map[string]string)
+	answerSetResolvers := make(map[string][]string)
+	var answerSetKeys []string
+	for _, result := range results {
+		key := comparisonAnswerSetKey(result)
+		if _, found := answerSetNames[key]; !found {
+			answerSetNames[key] = string(compareAnswerSetNames[len(answerSetKeys)%len(compareAnswerSetNames)])
+			answerSetKeys = append(answerSetKeys, key)
+		}
+		result.answerSet = answerSetNames[key]
+		answerSetResolvers[key] = append(answerSetResolvers[key], result.resolver.address)
+	}
+	table := &bytes.Buffer{}
+	fmt.Fprintf(table, compareTableRowFormat, "resolver", "rcode", "latency", "answer set")
+	for _, result := range results {
+		rcode, latency := "error", "-"
+		if result.err == nil {
+			rcode = dns.RcodeToString[result.response.Rcode]
+			latency = formatMilliseconds(result.duration)
+		}
+		fmt.Fprintf(table, compareTableRowFormat, fmt.Sprintf("%s (%s)", result.resolver.address,
+			result.resolver.operator), rcode, latency, result.answerSet)
+	}
+	summary := "All resolvers returned the same answer."
+	if len(answerSetKeys) > 1 {
+		summary = fmt.Sprintf("The resolvers disagree: %d distinct answers.", len(answerSetKeys))
+	}
+	messageEmbed.Description = fmt.Sprintf("Comparison of `%s %s`. %s\n```\n%s```", recordType.name, domain, summary,
+		table.String())
+	messageEmbed.Fields = make([]*discordgo.MessageEmbedField, 0, len(answerSetKeys))
+	for _, key := range answerSetKeys {
+		value := key
+		trimDiscordFieldValue(&value)
+		messageEmbed.Fields = append(messageEmbed.Fields, &discordgo.MessageEmbedField{
+			Name:  fmt.Sprintf("Answer set %s (%s):", answerSetNames[key], strings.Join(answerSetResolvers[key], ", ")),
+			Value: value,
+		})
+	}
+	for _, result := range results {
+		if result.err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// publicResolvers returns the built-in comparison resolvers followed by the configured ones.
+func (resolveHandler *ResolveHandler) publicResolvers() []publicResolver {
+	resolvers := append([]publicResolver{}, comparisonResolvers...)
+	for _, address := range resolveHandler.ComparisonResolvers {
+		resolvers = append(resolvers, publicResolver{address: address, operator: "configured"})
+	}
+	return resolvers
+}
+
+// compare sends the same question to every comparison resolver in parallel.
+func (resolveHandler *ResolveHandler) compare(domain string, messageType uint16) []*comparisonResult {
+	resolvers := resolveHandler.publicResolvers()
+	results := make([]*comparisonResult, len(resolvers))
+	waitGroup := &sync.WaitGroup{}
+	for index, resolver := range resolvers {
+		waitGroup.Add(1)
+		go func(index int, resolver publicResolver) {
+			defer waitGroup.Done()
+			message := &dns.Msg{}
+			message.SetQuestion(domain, messageType)
+			result := &comparisonResult{resolver: resolver}
+			result.response, result.duration, result.err = resolveHandler.exchangeDirect(message,
+				resolverAddress(resolver.address))
+			results[index] = result
+		}(index, resolver)
+	}
+	waitGroup.Wait()
+	return results
+}
+
+// comparisonAnswerSetKey renders the result of a resolver in a way that equal answers result in equal keys. TTLs are
+// ignored because they naturally differ between caches.
+func comparisonAnswerSetKey(result *comparisonResult) string {
+	if result.err != nil {
+		return "error: " + result.err.Error()
+	}
+	if result.response.Rcode != dns.RcodeSuccess {
+		errorMessage, _ := validateDNSResponseCode(result.response.Rcode)
+		return errorMessage
+	}
+	if len(result.response.Answer) == 0 {
+		return "no records"
+	}
+	answers := make([]string, len(result.response.Answer))
+	for index, answer := range result.response.Answer {
+		answers[index] = parseDNSAnswer(answer)
+	}
+	sort.Strings(answers)
+	return strings.Join(answers, "\n")
+}
+
+// resolverAddress appends the default DNS port to the given resolver address if it does not contain a port.
+func resolverAddress(address string) string {
+	if _, _, err := net.SplitHostPort(address); err == nil {
+		return address
+	}
+	return net.JoinHostPort(address, "53")
+}
+
+// formatMilliseconds renders a duration in milliseconds.
+func formatMilliseconds(duration time.Duration) string {
+	return strconv.FormatFloat(float64(duration)/float64(time.Millisecond), 'f', 1, 64) + "ms"
+}
@@ -0,0 +1,207 @@
+package discord1111resolver
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/bwmarrin/discordgo"
+	"github.com/miekg/dns"
+	"github.com/sirupsen/logrus"
+	"strconv"
+	"strings"
+)
+
+const (
+	// tLSANameFormat is used to build the owner name of the TLSA records of a TCP service (RFC 6698 section 3).
+	tLSANameFormat = "_%d._tcp.%s"
+	// tLSAParametersFormat is used to render the usage, selector and matching type of a TLSA record.
+	tLSAParametersFormat = "%d %d %d (%s, %s, %s)"
+	// unauthenticatedTLSAWarning is shown if the upstream did not authenticate the TLSA records.
+	unauthenticatedTLSAWarning = "**The TLSA records are NOT DNSSEC-authenticated** (the AD bit is not set). " +
+		"Unauthenticated TLSA records are meaningless because an attacker can forge them (RFC 6698 section 4.1)."
+)
+
+// tLSAUsageNames contains the mnemonics of the TLSA certificate usages (RFC 7218).
+var tLSAUsageNames = map[uint8]string{0: "PKIX-TA", 1: "PKIX-EE", 2: "DANE-TA", 3: "DANE-EE"}
+
+// tLSASelectorNames contains the mnemonics of the TLSA selectors (RFC 7218).
+var tLSASelectorNames = map[uint8]string{0: "Cert", 1: "SPKI"}
+
+// tLSAMatchingTypeNames contains the mnemonics of the TLSA matching types (RFC 7218).
+var tLSAMatchingTypeNames = map[uint8]string{0: "Full", 1: "SHA2-256", 2: "SHA2-512"}
+
+func (resolveHandler *ResolveHandler) handleDANECommand(messageCreate *discordgo.MessageCreate,
+	messageSend *messageReply, options *queryOptions, params []string) (ok bool) {
+	messageEmbed := messageSend.Embed
+	if len(params) != 2 {
+		return false
+	}
+	port, err := strconv.Atoi(params[0])
+	if err != nil || port < 1 || port > 65535 {
+		portString := params[0]
+		trimDiscordFieldValue(&portString)
+		messageEmbed.Fields = []*discordgo.MessageEmbedField{{
+			Name:   "Invalid port:",
+			Value:  strconv.Quote(portString),
+			Inline: true,
+		}}
+		return false
+	}
+	host, ok := prepareDomainName(messageEmbed, params[1])
+	if !ok {
+		return false
+	}
+	// the certificate chain is parsed first to report problems with the attachment without sending a query
+	var chain []*x509.Certificate
+	if len(messageCreate.Attachments) > 0 {
+		if chain, err = downloadCertificateChain(messageCreate.Attachments[0]); err != nil {
+			messageEmbed.Fields = []*discordgo.MessageEmbedField{{
+				Name:   "Could not read the attached certificate chain:",
+				Value:  strconv.Quote(err.Error()),
+				Inline: true,
+			}}
+			return false
+		}
+	}
+	name := fmt.Sprintf(tLSANameFormat, port, host)
+	message := &dns.Msg{}
+	message.SetQuestion(name, dns.TypeTLSA)
+	// the DNSSEC OK bit makes the upstream validate the records and report the result with the AD bit
+	message.SetEdns0(dNSSECUDPSize, true)
+	response, duration, err := resolveHandler.exchange(message)
+	if err != nil {
+		logrus.WithError(err).Warn("could not execute TLSA request")
+		messageEmbed.Fields = []*discordgo.MessageEmbedField{{
+			Name:   "Unknown error while executing the DNS request:",
+			Value:  strconv.Quote(err.Error()),
+			Inline: true,
+		}}
+		return false
+	}
+	if errorMessage, dNSResponseCodeOk := validateDNSResponseCode(response.Rcode); !dNSResponseCodeOk {
+		messageEmbed.Fields = []*discordgo.MessageEmbedField{{
+			Name:   "The DNS server returned an non-successful response code:",
+			Value:  errorMessage,
+			Inline: true,
+		}}
+		return false
+	}
+	var tlsaRecords []*dns.TLSA
+	for _, answer := range response.Answer {
+		if tlsa, isTLSA := answer.(*dns.TLSA); isTLSA {
+			tlsaRecords = append(tlsaRecords, tlsa)
+		}
+	}
+	if len(tlsaRecords) == 0 {
+		messageEmbed.Fields = []*discordgo.MessageEmbedField{{
+			Name:   "Could not find TLSA records:",
+			Value:  strconv.Quote(name),
+			Inline: true,
+		}}
+		return false
+	}
+	messageEmbed.Description = fmt.Sprintf("DANE check of `%s`.", name)
+	lines := make([]string, len(tlsaRecords))
+	for index, tlsa := range tlsaRecords {
+		lines[index] = formatTLSAParameters(tlsa) + " " + tlsa.Certificate
+	}
+	messageEmbed.Fields = []*discordgo.MessageEmbedField{verboseSectionField("TLSA records:", lines)}
+	ok = response.AuthenticatedData
+	if ok {
+		messageEmbed.Fields = append(messageEmbed.Fields, &discordgo.MessageEmbedField{
+			Name:  "DNSSEC:",
+			Value: successMarker + " The TLSA records are DNSSEC-authenticated (the upstream set the AD bit).",
+		})
+	} else {
+		messageEmbed.Fields = append(messageEmbed.Fields, &discordgo.MessageEmbedField{
+			Name:  "DNSSEC:",
+			Value: failureMarker + " " + unauthenticatedTLSAWarning,
+		})
+	}
+	if options.dnssec {
+		messageEmbed.Fields = append(messageEmbed.Fields, resolveHandler.validateDNSSEC(resolveHandler.Upstream, response)...)
+	}
+	if chain == nil {
+		appendDescription(messageEmbed, "Attach a PEM encoded certificate chain (leaf first) to verify it against the TLSA records.")
+	} else {
+		verification, matched := verifyTLSARecords(tlsaRecords, chain)
+		ok = ok && matched
+		messageEmbed.Fields = append(messageEmbed.Fields, &discordgo.MessageEmbedField{
+			Name:  "Certificate verification:",
+			Value: verification,
+		})
+	}
+	messageEmbed.Footer = &discordgo.MessageEmbedFooter{Text: fmt.Sprintf(dNSDurationFormat, duration, resolveHandler.Upstream.String())}
+	return ok
+}
+
+// downloadCertificateChain downloads and parses a PEM encoded certificate chain.
+func downloadCertificateChain(attachment *discordgo.MessageAttachment) ([]*x509.Certificate, error) {
+	content, err := downloadAttachment(attachment)
+	if err != nil {
+		return nil, err
+	}
+	var chain []*x509.Certificate
+	for {
+		var block *pem.Block
+		if block, content = pem.Decode(content); block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		certificate, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, certificate)
+	}
+	if len(chain) == 0 {
+		return nil, errors.New("the attachment does not contain a PEM encoded certificate")
+	}
+	return chain, nil
+}
+
+// verifyTLSARecords verifies every TLSA record against the certificate chain. End entity usages are verified against
+// the leaf certificate and trust anchor usages against the remaining certificates of the chain.
+func verifyTLSARecords(tlsaRecords []*dns.TLSA, chain []*x509.Certificate) (verification string, matched bool) {
+	lines := make([]string, len(tlsaRecords))
+	for index, tlsa := range tlsaRecords {
+		candidates, offset := chain[:1], 0
+		if tlsa.Usage == 0 || tlsa.Usage == 2 {
+			candidates, offset = chain[1:], 1
+		}
+		lines[index] = fmt.Sprintf("%s `%s` matches no certificate", failureMarker, formatTLSAParameters(tlsa))
+		for candidateIndex, certificate := range candidates {
+			if tlsa.Verify(certificate) != nil {
+				continue
+			}
+			matched = true
+			lines[index] = fmt.Sprintf("%s `%s` matches certificate #%d (%s)", successMarker, formatTLSAParameters(tlsa),
+				candidateIndex+offset+1, certificate.Subject.CommonName)
+			// the PKIX usages additionally require a valid certification path which is not checked here
+			if tlsa.Usage < 2 {
+				lines[index] += ", PKIX path validation not checked"
+			}
+			break
+		}
+	}
+	verification = strings.Join(lines, "\n")
+	trimDiscordFieldValue(&verification)
+	return verification, matched
+}
+
+// formatTLSAParameters renders the usage, selector and matching type of a TLSA record with their mnemonics.
+func formatTLSAParameters(tlsa *dns.TLSA) string {
+	return fmt.Sprintf(tLSAParametersFormat, tlsa.Usage, tlsa.Selector, tlsa.MatchingType, formatTLSAMnemonic(tLSAUsageNames, tlsa.Usage),
+		formatTLSAMnemonic(tLSASelectorNames, tlsa.Selector), formatTLSAMnemonic(tLSAMatchingTypeNames, tlsa.MatchingType))
+}
+
+// formatTLSAMnemonic returns the mnemonic of a TLSA parameter or "unknown" if it is not assigned.
+func formatTLSAMnemonic(names map[uint8]string, value uint8) string {
+	if name, found := names[value]; found {
+		return name
+	}
+	return "unknown"
+}
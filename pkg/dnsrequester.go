@@ -6,15 +6,22 @@ import (
 	"github.com/miekg/dns"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/net/idna"
+	"net"
 	"strconv"
 	"strings"
+	"time"
 )
 
 const (
-	dNSServer                 = "1.1.1.1:853"
 	unknownResponseCodeFormat = "unknown response code (%d)"
-	dNSDurationFormat         = "Got answer in %v."
-	dNSAnswerValueFormat      = "%s - `%s`"
+	dNSDurationFormat         = "Got answer in %v via %s."
+	dNSAnswerValueFormat      = "%s - %s"
+	// cNAMEChainFormat is used to render the resolution chain of a query which was answered with CNAME records.
+	cNAMEChainFormat = "Resolution chain: %s"
+	// dNSBlockedFormat is used to point out that a filtering resolver profile blocked the queried name.
+	dNSBlockedFormat = ":no_entry: `%s` is **blocked** by the %s resolver profile."
+	// directQueryTimeout is the default timeout of queries which are sent directly to other DNS servers.
+	directQueryTimeout = 3 * time.Second
 )
 
 // dNSResponseCodeMessages contains DNS response codes and fitting error messages
@@ -26,16 +33,11 @@ var dNSResponseCodeMessages = map[int]string{
 
 var profile = idna.New() //PunyCode resolver profile
 
-func (resolveHandler *ResolveHandler) executeDNSRequest(messageEmbed *discordgo.MessageEmbed, dNSMessageType uint16, dNSMessageTypeString string, domain string) (ok bool) {
+func (resolveHandler *ResolveHandler) executeDNSRequest(messageSend *messageReply, dNSMessageType uint16, dNSMessageTypeString string, domain string, options *queryOptions) (ok bool) {
+	messageEmbed := messageSend.Embed
 	// encode punycode
-	punycodeDomain, err := profile.ToASCII(domain)
-	if err != nil {
-		logrus.WithError(err).Warn("could not encode unicode to punycode")
-		messageEmbed.Fields = []*discordgo.MessageEmbedField{{
-			Name:   "An error occurred while decoding a punycode domain:",
-			Value:  strconv.Quote(err.Error()),
-			Inline: true,
-		}}
+	punycodeDomain, ok := encodeDomainName(messageEmbed, domain)
+	if !ok {
 		return false
 	}
 	// create new message instance from the parameter data
@@ -43,12 +45,45 @@ func (resolveHandler *ResolveHandler) executeDNSRequest(messageEmbed *discordgo.
 		Question: []dns.Question{{
 			Name:   dns.Fqdn(punycodeDomain),
 			Qtype:  dNSMessageType,
-			Qclass: dns.ClassINET,
+			Qclass: options.class,
 		}},
 	}
 	message.RecursionDesired = true
+	if options.dnssec {
+		prepareDNSSECMessage(message)
+	}
+	upstream, ok := resolveHandler.selectUpstream(messageEmbed, options)
+	if !ok {
+		return false
+	}
+	var clientSubnet *net.IPNet
+	var clientSubnetPreset string
+	if options.geo != "" {
+		if clientSubnet, clientSubnetPreset, ok = resolveHandler.resolveClientSubnet(messageEmbed, options.geo); !ok {
+			return false
+		}
+		setClientSubnet(message, clientSubnet)
+	}
+	// the server identifier shows which data centre of an anycast resolver served the answer
+	requestNSID(message)
 	// execute DNS request
-	response, duration, err := resolveHandler.DNSClient.Exchange(message, dNSServer)
+	response, duration, cached, err := resolveHandler.cachedExchange(upstream, message,
+		responseCacheKey(message, upstream, options))
+	if pinMismatchErr, isPinMismatch := err.(*SPKIPinMismatchError); isPinMismatch {
+		logrus.WithError(err).WithField("server-name", pinMismatchErr.ServerName).
+			WithField("presented-pins", pinMismatchErr.Pins).Error("upstream certificate does not match the SPKI pins")
+		presentedPins := strings.Join(pinMismatchErr.Pins, "\n")
+		trimDiscordFieldValue(&presentedPins)
+		messageEmbed.Fields = []*discordgo.MessageEmbedField{{
+			Name:   "The upstream resolver could not be authenticated:",
+			Value:  fmt.Sprintf("None of the certificates presented by `%s` matches the configured SPKI pins.", pinMismatchErr.ServerName),
+			Inline: true,
+		}, {
+			Name:  "Presented SPKI fingerprints:",
+			Value: presentedPins,
+		}}
+		return false
+	}
 	if err != nil {
 		logrus.WithError(err).Warn("could not execute DNS request")
 		messageEmbed.Fields = []*discordgo.MessageEmbedField{{
@@ -58,21 +93,76 @@ func (resolveHandler *ResolveHandler) executeDNSRequest(messageEmbed *discordgo.
 		}}
 		return false
 	}
+	if cached.staleAge > 0 {
+		appendDescription(messageEmbed, fmt.Sprintf(staleResponseFormat, cached.staleReason.Error(),
+			cached.staleAge/time.Second*time.Second))
+	}
+	if options.verbose {
+		// render the complete response, even if the response code is not successful
+		messageEmbed.Fields = verboseFields(response)
+		if clientSubnet != nil {
+			messageEmbed.Fields = append(messageEmbed.Fields, clientSubnetField(response, clientSubnet, clientSubnetPreset))
+		}
+		if options.dnssec && (response.Rcode == dns.RcodeSuccess || response.Rcode == dns.RcodeNameError) {
+			messageEmbed.Fields = append(messageEmbed.Fields, resolveHandler.validateDNSSEC(upstream, response)...)
+		}
+		messageEmbed.Footer = dNSResponseFooter(response, duration, upstream, cached)
+		if options.wire || exceedsEmbedLimits(messageEmbed) {
+			attachDNSResponse(messageSend.MessageSend, message.Question[0].Name, response, options.wire)
+		}
+		return response.Rcode == dns.RcodeSuccess
+	}
 	if errorMessage, dNSResponseCodeOk := validateDNSResponseCode(response.Rcode); !dNSResponseCodeOk {
 		messageEmbed.Fields = []*discordgo.MessageEmbedField{{
 			Name:   "The DNS server returned an non-successful response code:",
 			Value:  errorMessage,
 			Inline: true,
 		}}
+		// a non-existent name is validated by its denial of existence
+		if options.dnssec && response.Rcode == dns.RcodeNameError {
+			messageEmbed.Fields = append(messageEmbed.Fields, resolveHandler.validateDNSSEC(upstream, response)...)
+		}
+		// negative responses are cached as well (RFC 2308)
+		if cached.remaining > 0 || cached.staleAge > 0 {
+			messageEmbed.Footer = dNSResponseFooter(response, duration, upstream, cached)
+		}
 		return false
 	}
 	if len(response.Answer) > 0 {
-		messageEmbed.Fields = make([]*discordgo.MessageEmbedField, len(response.Answer))
-		for index, answer := range response.Answer {
-			messageEmbed.Fields[index] = &discordgo.MessageEmbedField{
-				Name:  domain,
-				Value: parseDNSAnswer(answer),
+		// follow (and complete) the CNAME chain, the records of every chain link are part of the answer
+		chain := followCNAMEChain(upstream.Exchange, message, response)
+		response.Answer = chain.answers
+		if len(chain.names) > 1 {
+			appendDescription(messageEmbed, fmt.Sprintf(cNAMEChainFormat, chain.render(dNSRecordTypesByCode[dNSMessageType])))
+		}
+		messageEmbed.Fields = make([]*discordgo.MessageEmbedField, 0, len(response.Answer))
+		for _, answer := range response.Answer {
+			// signatures are summarized by the DNSSEC validation fields
+			if answer.Header().Rrtype == dns.TypeRRSIG {
+				continue
 			}
+			answerValue := parseDNSAnswer(answer)
+			trimDiscordFieldValue(&answerValue)
+			messageEmbed.Fields = append(messageEmbed.Fields, &discordgo.MessageEmbedField{
+				Name:  answer.Header().Name,
+				Value: answerValue,
+			})
+		}
+		if chain.problem != "" {
+			ok = false
+			messageEmbed.Fields = append(messageEmbed.Fields, &discordgo.MessageEmbedField{
+				Name:  "The CNAME chain could not be followed:",
+				Value: chain.problem,
+			})
+		}
+		if options.dnssec {
+			messageEmbed.Fields = append(messageEmbed.Fields, resolveHandler.validateDNSSEC(upstream, response)...)
+		}
+		if clientSubnet != nil {
+			messageEmbed.Fields = append(messageEmbed.Fields, clientSubnetField(response, clientSubnet, clientSubnetPreset))
+		}
+		if options.profile != "" && isBlockedResponse(response) {
+			appendDescription(messageEmbed, fmt.Sprintf(dNSBlockedFormat, domain, options.profile))
 		}
 	} else {
 		messageEmbed.Fields = []*discordgo.MessageEmbedField{{
@@ -80,24 +170,192 @@ func (resolveHandler *ResolveHandler) executeDNSRequest(messageEmbed *discordgo.
 			Value:  strconv.Quote(strings.ToUpper(dNSMessageTypeString)),
 			Inline: true,
 		}}
+		if options.dnssec {
+			messageEmbed.Fields = append(messageEmbed.Fields, resolveHandler.validateDNSSEC(upstream, response)...)
+		}
+		if cached.remaining > 0 || cached.staleAge > 0 {
+			messageEmbed.Footer = dNSResponseFooter(response, duration, upstream, cached)
+		}
 		return false
 	}
-	messageEmbed.Footer = &discordgo.MessageEmbedFooter{Text: fmt.Sprintf(dNSDurationFormat, duration)}
-	return true
+	messageEmbed.Footer = dNSResponseFooter(response, duration, upstream, cached)
+	// attach the complete response if it does not fit into the message embed
+	if options.wire || exceedsEmbedLimits(messageEmbed) {
+		attachDNSResponse(messageSend.MessageSend, message.Question[0].Name, response, options.wire)
+	}
+	return ok
+}
+
+// dNSResponseFooter describes the duration of the query, the upstream and (if it was returned) the NSID of the server
+// which answered. Responses from the cache are marked with their remaining TTL and stale responses with their age.
+func dNSResponseFooter(response *dns.Msg, duration time.Duration, upstream Upstream, cached cacheStatus) *discordgo.MessageEmbedFooter {
+	text := fmt.Sprintf(dNSDurationFormat, duration, upstream.String())
+	switch {
+	case cached.staleAge > 0:
+		text = fmt.Sprintf(staleResponseFooterFormat, cached.staleAge/time.Second*time.Second) + text
+	case cached.remaining > 0:
+		text = fmt.Sprintf(cachedResponseFormat, int(cached.remaining/time.Second)) + text
+	}
+	if nsid := responseNSID(response); nsid != "" {
+		text += fmt.Sprintf(nSIDFooterFormat, nsid)
+	}
+	return &discordgo.MessageEmbedFooter{Text: text}
+}
+
+// appendDescription appends a line to the description of the message embed.
+func appendDescription(messageEmbed *discordgo.MessageEmbed, line string) {
+	if messageEmbed.Description != "" {
+		messageEmbed.Description += "\n"
+	}
+	messageEmbed.Description += line
+}
+
+// encodeDomainName encodes the given (unicode) domain name to punycode and sets an error field if that fails.
+func encodeDomainName(messageEmbed *discordgo.MessageEmbed, domain string) (punycodeDomain string, ok bool) {
+	punycodeDomain, err := profile.ToASCII(domain)
+	if err != nil {
+		logrus.WithError(err).Warn("could not encode unicode to punycode")
+		messageEmbed.Fields = []*discordgo.MessageEmbedField{{
+			Name:   "An error occurred while decoding a punycode domain:",
+			Value:  strconv.Quote(err.Error()),
+			Inline: true,
+		}}
+		return "", false
+	}
+	return punycodeDomain, true
+}
+
+// prepareDomainName validates the user specified domain name and returns its fully qualified punycode form.
+func prepareDomainName(messageEmbed *discordgo.MessageEmbed, domain string) (fqdn string, ok bool) {
+	if !validateDomainName(messageEmbed, domain) {
+		return "", false
+	}
+	punycodeDomain, ok := encodeDomainName(messageEmbed, domain)
+	if !ok {
+		return "", false
+	}
+	return dns.Fqdn(punycodeDomain), true
+}
+
+// exchangeDirect sends the given DNS message over plain UDP to the given server (e.g. an authoritative name server)
+// and retries it over TCP if the response is truncated.
+func (resolveHandler *ResolveHandler) exchangeDirect(message *dns.Msg, address string) (*dns.Msg, time.Duration, error) {
+	response, duration, err := resolveHandler.directClient("udp").Exchange(message, address)
+	if err == nil && response.Truncated {
+		return resolveHandler.directClient("tcp").Exchange(message, address)
+	}
+	return response, duration, err
+}
+
+// directClient creates a plain DNS client for the given network which uses the dialer and timeout of the configured
+// DNS client.
+func (resolveHandler *ResolveHandler) directClient(network string) *dns.Client {
+	timeout := resolveHandler.DNSClient.Timeout
+	if timeout == 0 {
+		timeout = directQueryTimeout
+	}
+	return &dns.Client{
+		Net:     network,
+		UDPSize: dns.DefaultMsgSize,
+		Dialer:  resolveHandler.DNSClient.Dialer,
+		Timeout: timeout,
+	}
+}
+
+// selectUpstream returns the upstream resolver which honours EDNS Client Subnet if a client subnet was requested, the
+// upstream resolver of the requested profile or the default upstream.
+func (resolveHandler *ResolveHandler) selectUpstream(messageEmbed *discordgo.MessageEmbed, options *queryOptions) (upstream Upstream, ok bool) {
+	if options.geo != "" {
+		if options.profile != "" {
+			messageEmbed.Fields = []*discordgo.MessageEmbedField{{
+				Name:   "Invalid combination of options:",
+				Value:  "The filtering resolver profiles ignore EDNS Client Subnet, --geo can not be combined with --profile.",
+				Inline: true,
+			}}
+			return nil, false
+		}
+		return resolveHandler.ECSUpstream, true
+	}
+	if options.profile == "" {
+		return resolveHandler.Upstream, true
+	}
+	if upstream, ok = resolveHandler.Profiles[options.profile]; !ok {
+		profileName := options.profile
+		trimDiscordFieldValue(&profileName)
+		messageEmbed.Fields = []*discordgo.MessageEmbedField{{
+			Name:   "Unknown resolver profile:",
+			Value:  strconv.Quote(profileName),
+			Inline: true,
+		}}
+	}
+	return
+}
+
+// isBlockedResponse checks whether a filtering resolver blocked the queried name. Blocked names are answered with the
+// unspecified addresses 0.0.0.0 or ::.
+func isBlockedResponse(response *dns.Msg) bool {
+	for _, answer := range response.Answer {
+		switch answerType := answer.(type) {
+		case *dns.A:
+			if answerType.A.IsUnspecified() {
+				return true
+			}
+		case *dns.AAAA:
+			if answerType.AAAA.IsUnspecified() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// exchange sends the given DNS message to the configured upstream resolver.
+func (resolveHandler *ResolveHandler) exchange(message *dns.Msg) (*dns.Msg, time.Duration, error) {
+	return resolveHandler.Upstream.Exchange(message)
+}
+
+// validateDNSSEC validates the chain of trust of the given response and returns the fields which describe the result.
+func (resolveHandler *ResolveHandler) validateDNSSEC(upstream Upstream, response *dns.Msg) []*discordgo.MessageEmbedField {
+	validator := newDNSSECValidator(upstream.Exchange)
+	status := validator.validate(response)
+	logrus.WithField("status", status).WithField("failing-link", validator.failingLink).Debug("validated DNSSEC chain of trust.")
+	fields := []*discordgo.MessageEmbedField{{
+		Name:   "DNSSEC status:",
+		Value:  dNSSECStatusNames[status],
+		Inline: true,
+	}, {
+		Name:   "Upstream AD flag:",
+		Value:  strconv.FormatBool(response.AuthenticatedData),
+		Inline: true,
+	}}
+	if len(validator.chain) > 0 {
+		chain := strings.Join(validator.chain, "\n")
+		trimDiscordFieldValue(&chain)
+		fields = append(fields, &discordgo.MessageEmbedField{
+			Name:  "Chain of trust:",
+			Value: chain,
+		})
+	}
+	if validator.failingLink != "" {
+		failingLink := validator.failingLink
+		trimDiscordFieldValue(&failingLink)
+		fields = append(fields, &discordgo.MessageEmbedField{
+			Name:  "Failing link:",
+			Value: failingLink,
+		})
+	}
+	return fields
 }
 
 func parseDNSAnswer(answer dns.RR) string {
-	switch answerType := interface{}(answer).(type) {
-	case *dns.A:
-		return fmt.Sprintf(dNSAnswerValueFormat, "A", answerType.A.String())
-	case *dns.AAAA:
-		return fmt.Sprintf(dNSAnswerValueFormat, "AAAA", answerType.AAAA.String())
-	case *dns.CNAME:
-		return fmt.Sprintf(dNSAnswerValueFormat, "CNAME", answerType.Target)
-	default:
-		logrus.WithField("answer-type", fmt.Sprintf("%T", answerType)).Warn("could not parse answer type")
-		return "invalid answer type"
+	answerType := answer.Header().Rrtype
+	if recordType, ok := dNSRecordTypesByCode[answerType]; ok {
+		return fmt.Sprintf(dNSAnswerValueFormat, recordType.name, recordType.format(answer))
 	}
+	// fall back to the presentation format of the miekg dns library for unsupported types
+	logrus.WithField("answer-type", fmt.Sprintf("%T", answer)).Debug("rendering unsupported answer type")
+	answerValue := strings.TrimPrefix(answer.String(), answer.Header().String())
+	return fmt.Sprintf(dNSAnswerValueFormat, dns.TypeToString[answerType], fmt.Sprintf(dNSAnswerCodeFormat, answerValue))
 }
 
 func validateDNSResponseCode(dNSResponseCode int) (errorMessage string, ok bool) {
@@ -0,0 +1,751 @@
+package discord1111resolver
+
+import (
+	"errors"
+	"fmt"
+	"github.com/miekg/dns"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dNSSECStatus is the result of a local DNSSEC chain of trust validation. A higher value is always worse than a lower one.
+type dNSSECStatus int
+
+const (
+	// dNSSECSecure means that the chain of trust from the root trust anchor down to the answer is intact.
+	dNSSECSecure dNSSECStatus = iota
+	// dNSSECInsecure means that a delegation on the way to the answer is provably unsigned.
+	dNSSECInsecure
+	// dNSSECBogus means that a link of the chain of trust is broken (e.g. an invalid signature or a DS mismatch).
+	dNSSECBogus
+)
+
+// dNSSECStatusNames contains the presentation names of all DNSSEC validation results.
+var dNSSECStatusNames = map[dNSSECStatus]string{
+	dNSSECSecure:   "secure :white_check_mark:",
+	dNSSECInsecure: "insecure :warning:",
+	dNSSECBogus:    "bogus :x:",
+}
+
+// dNSSECUDPSize is the EDNS0 UDP buffer size advertised for DNSSEC queries.
+const dNSSECUDPSize = 4096
+
+// nSEC3OptOut is the opt-out flag of NSEC3 records (RFC 5155 section 3.1.2.1).
+const nSEC3OptOut = 1
+
+// rootTrustAnchors contains the DS records of the root zone key signing keys (KSK-2017 and KSK-2024) as published by
+// IANA.
+var rootTrustAnchors = []*dns.DS{
+	{
+		Hdr:        dns.RR_Header{Name: ".", Rrtype: dns.TypeDS, Class: dns.ClassINET},
+		KeyTag:     20326,
+		Algorithm:  dns.RSASHA256,
+		DigestType: dns.SHA256,
+		Digest:     "E06D44B80B8F1D39A95C0B0D7C65D08458E880409BBC683457104237C7F8EC8D",
+	},
+	{
+		Hdr:        dns.RR_Header{Name: ".", Rrtype: dns.TypeDS, Class: dns.ClassINET},
+		KeyTag:     38696,
+		Algorithm:  dns.RSASHA256,
+		DigestType: dns.SHA256,
+		Digest:     "683D2D0ACB8C9B712A1948B27F741219298D0A450D612C483AF444A4C0FB2B16",
+	},
+}
+
+// dNSSECZone contains the validated state of a zone's DNSKEY RRset.
+type dNSSECZone struct {
+	status dNSSECStatus
+	keys   []*dns.DNSKEY
+}
+
+// dNSSECValidator validates DNS responses locally by walking the chain of trust (DS and DNSKEY RRsets) from the
+// answer up to the built-in root trust anchors.
+type dNSSECValidator struct {
+	// exchange sends a DNS message to the upstream resolver.
+	exchange func(message *dns.Msg) (*dns.Msg, time.Duration, error)
+	// zones contains all zones which were already validated during this validation run.
+	zones map[string]*dNSSECZone
+	// chain contains a human readable description of every validated link.
+	chain []string
+	// failingLink describes the first link which could not be validated.
+	failingLink string
+}
+
+// newDNSSECValidator creates a validator which sends its queries with the given exchange function.
+func newDNSSECValidator(exchange func(message *dns.Msg) (*dns.Msg, time.Duration, error)) *dNSSECValidator {
+	return &dNSSECValidator{
+		exchange: exchange,
+		zones:    make(map[string]*dNSSECZone),
+	}
+}
+
+// prepareDNSSECMessage sets the DO bit and disables upstream checking so that bogus data is returned instead of a
+// server failure and can be validated locally.
+func prepareDNSSECMessage(message *dns.Msg) {
+	message.SetEdns0(dNSSECUDPSize, true)
+	message.CheckingDisabled = true
+}
+
+// validate validates every RRset of the answer section of the given response and returns the worst result. Responses
+// without answer records are validated by their denial of existence.
+func (validator *dNSSECValidator) validate(response *dns.Msg) dNSSECStatus {
+	status := validator.zone(".").status
+	if status != dNSSECSecure {
+		return status
+	}
+	rrsets := splitRRSets(response.Answer)
+	if len(rrsets) == 0 {
+		return validator.validateNegative(response)
+	}
+	for _, rrset := range rrsets {
+		owner := rrset[0].Header().Name
+		rrsetStatus := validator.validateRRSet(rrset, findRRSIGs(response.Answer, owner, rrset[0].Header().Rrtype),
+			response.Ns)
+		if rrsetStatus > status {
+			status = rrsetStatus
+		}
+	}
+	return status
+}
+
+// validateRRSet validates a single RRset with its covering signatures. RRsets expanded from a wildcard additionally
+// need the NSEC/NSEC3 records of the authority section to prove that no closer match exists.
+func (validator *dNSSECValidator) validateRRSet(rrset []dns.RR, signatures []*dns.RRSIG, authority []dns.RR) dNSSECStatus {
+	owner := rrset[0].Header().Name
+	rrsetName := fmt.Sprintf("%s %s", owner, dns.TypeToString[rrset[0].Header().Rrtype])
+	if len(signatures) == 0 {
+		return validator.proveInsecure(owner, rrsetName)
+	}
+	signerName := signatures[0].SignerName
+	if !dns.IsSubDomain(signerName, owner) {
+		return validator.fail(fmt.Sprintf("%s is signed by %s which is not an ancestor", rrsetName, signerName))
+	}
+	zone := validator.zone(signerName)
+	if zone.status != dNSSECSecure {
+		return zone.status
+	}
+	signature, err := verifyRRSet(rrset, signatures, zone.keys)
+	if err != nil {
+		return validator.fail(fmt.Sprintf("%s: %s", rrsetName, err.Error()))
+	}
+	// the labels field of the signature is lower than the number of labels of the owner for wildcard expansions
+	if int(signature.Labels) < dns.CountLabel(owner) && !strings.HasPrefix(owner, "*.") {
+		var proofRecords []dns.RR
+		for _, denialSet := range denialRRSets(authority) {
+			denialSignatures := findRRSIGs(authority, denialSet[0].Header().Name, denialSet[0].Header().Rrtype)
+			if len(denialSignatures) == 0 || !strings.EqualFold(denialSignatures[0].SignerName, signerName) {
+				continue
+			}
+			if _, err := verifyRRSet(denialSet, denialSignatures, zone.keys); err != nil {
+				return validator.fail(fmt.Sprintf("%s is expanded from a wildcard but its proof is invalid: %s",
+					rrsetName, err.Error()))
+			}
+			proofRecords = append(proofRecords, denialSet...)
+		}
+		if err := proveWildcardExpansion(proofRecords, owner, int(signature.Labels)); err != nil {
+			return validator.fail(fmt.Sprintf("%s is expanded from a wildcard: %s", rrsetName, err.Error()))
+		}
+		validator.chain = append(validator.chain, fmt.Sprintf("%s signed by %s (key tag %d, expanded from a wildcard)",
+			rrsetName, signerName, signature.KeyTag))
+		return dNSSECSecure
+	}
+	validator.chain = append(validator.chain, fmt.Sprintf("%s signed by %s (key tag %d)", rrsetName, signerName,
+		signature.KeyTag))
+	return dNSSECSecure
+}
+
+// zone returns the validated DNSKEY RRset of the given zone. Zones are only validated once per validation run.
+func (validator *dNSSECValidator) zone(zoneName string) *dNSSECZone {
+	zoneName = strings.ToLower(dns.Fqdn(zoneName))
+	if zone, ok := validator.zones[zoneName]; ok {
+		return zone
+	}
+	// mark the zone as bogus while it is validated to break any signer loops
+	validator.zones[zoneName] = &dNSSECZone{status: dNSSECBogus}
+	zone := validator.validateZone(zoneName)
+	validator.zones[zoneName] = zone
+	return zone
+}
+
+func (validator *dNSSECValidator) validateZone(zoneName string) *dNSSECZone {
+	var dsSet []*dns.DS
+	if zoneName == "." {
+		dsSet = rootTrustAnchors
+	} else {
+		var status dNSSECStatus
+		if dsSet, status = validator.delegationSigners(zoneName); status != dNSSECSecure {
+			return &dNSSECZone{status: status}
+		}
+	}
+	response, err := validator.query(zoneName, dns.TypeDNSKEY)
+	if err != nil {
+		return &dNSSECZone{status: validator.fail(fmt.Sprintf("%s DNSKEY could not be queried: %s", zoneName, err.Error()))}
+	}
+	var keys []*dns.DNSKEY
+	rrset := make([]dns.RR, 0, len(response.Answer))
+	for _, answer := range response.Answer {
+		if key, ok := answer.(*dns.DNSKEY); ok && strings.EqualFold(key.Hdr.Name, zoneName) {
+			keys = append(keys, key)
+			rrset = append(rrset, key)
+		}
+	}
+	if len(keys) == 0 {
+		return &dNSSECZone{status: validator.fail(fmt.Sprintf("%s has a DS record but no DNSKEY records", zoneName))}
+	}
+	// find the key signing keys which match a DS record of the parent (or the trust anchor)
+	var keySigningKeys []*dns.DNSKEY
+	var matchedTags []string
+	for _, ds := range dsSet {
+		for _, key := range keys {
+			if key.KeyTag() != ds.KeyTag || key.Algorithm != ds.Algorithm {
+				continue
+			}
+			if keyDS := key.ToDS(ds.DigestType); keyDS != nil && strings.EqualFold(keyDS.Digest, ds.Digest) {
+				keySigningKeys = append(keySigningKeys, key)
+				matchedTags = append(matchedTags, strconv.Itoa(int(ds.KeyTag)))
+			}
+		}
+	}
+	if len(keySigningKeys) == 0 {
+		return &dNSSECZone{status: validator.fail(fmt.Sprintf("%s DNSKEY: no key matches the DS records %s", zoneName,
+			formatDSKeyTags(dsSet)))}
+	}
+	signature, err := verifyRRSet(rrset, findRRSIGs(response.Answer, zoneName, dns.TypeDNSKEY), keySigningKeys)
+	if err != nil {
+		return &dNSSECZone{status: validator.fail(fmt.Sprintf("%s DNSKEY: %s", zoneName, err.Error()))}
+	}
+	anchor := "DS"
+	if zoneName == "." {
+		anchor = "trust anchor"
+	}
+	validator.chain = append(validator.chain, fmt.Sprintf("%s DNSKEY signed by key tag %d (matches %s %s)", zoneName,
+		signature.KeyTag, anchor, strings.Join(matchedTags, ", ")))
+	return &dNSSECZone{status: dNSSECSecure, keys: keys}
+}
+
+// delegationSigners returns the validated DS RRset of the given zone. If the parent provably does not have a DS
+// RRset for the zone, the zone is insecure.
+func (validator *dNSSECValidator) delegationSigners(zoneName string) ([]*dns.DS, dNSSECStatus) {
+	response, err := validator.query(zoneName, dns.TypeDS)
+	if err != nil {
+		return nil, validator.fail(fmt.Sprintf("%s DS could not be queried: %s", zoneName, err.Error()))
+	}
+	var dsSet []*dns.DS
+	rrset := make([]dns.RR, 0, len(response.Answer))
+	for _, answer := range response.Answer {
+		if ds, ok := answer.(*dns.DS); ok && strings.EqualFold(ds.Hdr.Name, zoneName) {
+			dsSet = append(dsSet, ds)
+			rrset = append(rrset, ds)
+		}
+	}
+	if len(dsSet) == 0 {
+		return nil, validator.validateUnsignedDelegation(response, zoneName, fmt.Sprintf("%s has no DS record", zoneName))
+	}
+	signatures := findRRSIGs(response.Answer, zoneName, dns.TypeDS)
+	if len(signatures) == 0 {
+		return nil, validator.fail(fmt.Sprintf("%s DS is not signed", zoneName))
+	}
+	parentName := signatures[0].SignerName
+	if !dns.IsSubDomain(parentName, zoneName) || strings.EqualFold(parentName, zoneName) {
+		return nil, validator.fail(fmt.Sprintf("%s DS is signed by %s which is not a parent zone", zoneName, parentName))
+	}
+	parent := validator.zone(parentName)
+	if parent.status != dNSSECSecure {
+		return nil, parent.status
+	}
+	signature, err := verifyRRSet(rrset, signatures, parent.keys)
+	if err != nil {
+		return nil, validator.fail(fmt.Sprintf("%s DS: %s", zoneName, err.Error()))
+	}
+	validator.chain = append(validator.chain, fmt.Sprintf("%s DS %s signed by %s (key tag %d)", zoneName,
+		formatDSKeyTags(dsSet), parentName, signature.KeyTag))
+	return dsSet, dNSSECSecure
+}
+
+// proveInsecure is called for unsigned RRsets. It walks down from the top level domain to the owner name and looks
+// for a delegation without a DS record whose absence is proven by a signed NSEC/NSEC3 denial of the parent zone.
+func (validator *dNSSECValidator) proveInsecure(owner string, rrsetName string) dNSSECStatus {
+	labelIndexes := dns.Split(owner)
+	for index := len(labelIndexes) - 1; index >= 0; index-- {
+		name := strings.ToLower(owner[labelIndexes[index]:])
+		if zone, ok := validator.zones[name]; ok {
+			if zone.status != dNSSECSecure {
+				return zone.status
+			}
+			continue
+		}
+		response, err := validator.query(name, dns.TypeDS)
+		if err != nil {
+			return validator.fail(fmt.Sprintf("%s DS could not be queried: %s", name, err.Error()))
+		}
+		if containsRRType(response.Answer, dns.TypeDS) {
+			if status := validator.zone(name).status; status != dNSSECSecure {
+				return status
+			}
+			continue
+		}
+		isDelegation, err := validator.isDelegation(name)
+		if err != nil {
+			return validator.fail(fmt.Sprintf("%s NS could not be queried: %s", name, err.Error()))
+		}
+		if isDelegation {
+			return validator.validateUnsignedDelegation(response, name, fmt.Sprintf("%s is an unsigned delegation", name))
+		}
+	}
+	return validator.fail(fmt.Sprintf("%s is not signed although its zone is secure", rrsetName))
+}
+
+// isDelegation checks whether the given name is the apex of a zone.
+func (validator *dNSSECValidator) isDelegation(name string) (bool, error) {
+	response, err := validator.query(name, dns.TypeNS)
+	if err != nil {
+		return false, err
+	}
+	for _, answer := range response.Answer {
+		if ns, ok := answer.(*dns.NS); ok && strings.EqualFold(ns.Hdr.Name, name) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// validateNegative validates a response without answer records (NXDOMAIN or NODATA) with the NSEC/NSEC3 records of its
+// authority section.
+func (validator *dNSSECValidator) validateNegative(response *dns.Msg) dNSSECStatus {
+	question := response.Question[0]
+	nameError := response.Rcode == dns.RcodeNameError
+	linkDescription := fmt.Sprintf("%s has no %s record", question.Name, dns.TypeToString[question.Qtype])
+	if nameError {
+		linkDescription = fmt.Sprintf("%s does not exist", question.Name)
+	}
+	return validator.validateDenial(response, linkDescription, func(records []dns.RR) error {
+		return proveNonexistence(records, question.Name, question.Qtype, nameError)
+	})
+}
+
+// validateUnsignedDelegation validates the denial of the DS RRset of a delegation, which makes the delegation insecure.
+func (validator *dNSSECValidator) validateUnsignedDelegation(response *dns.Msg, name string, linkDescription string) dNSSECStatus {
+	status := validator.validateDenial(response, linkDescription, func(records []dns.RR) error {
+		return proveUnsignedDelegation(records, name)
+	})
+	if status == dNSSECSecure {
+		return dNSSECInsecure
+	}
+	return status
+}
+
+// validateDenial validates the NSEC/NSEC3 records of a negative response. They have to be signed by the zone of the
+// SOA record and the prove function has to accept them as a proof of the denial.
+func (validator *dNSSECValidator) validateDenial(response *dns.Msg, linkDescription string, prove func(records []dns.RR) error) dNSSECStatus {
+	var signerName string
+	for _, rr := range response.Ns {
+		if soa, ok := rr.(*dns.SOA); ok {
+			signerName = soa.Hdr.Name
+		}
+	}
+	denialSets := denialRRSets(response.Ns)
+	if signerName == "" {
+		return validator.fail(fmt.Sprintf("%s but the response does not contain a SOA record", linkDescription))
+	}
+	parent := validator.zone(signerName)
+	if parent.status != dNSSECSecure {
+		return parent.status
+	}
+	if len(denialSets) == 0 {
+		return validator.fail(fmt.Sprintf("%s but %s did not prove it with NSEC/NSEC3 records", linkDescription,
+			signerName))
+	}
+	for _, rrset := range denialSets {
+		signatures := findRRSIGs(response.Ns, rrset[0].Header().Name, rrset[0].Header().Rrtype)
+		if _, err := verifyRRSet(rrset, signatures, parent.keys); err != nil {
+			return validator.fail(fmt.Sprintf("%s but its denial of existence is invalid: %s", linkDescription,
+				err.Error()))
+		}
+	}
+	if err := prove(response.Ns); err != nil {
+		return validator.fail(fmt.Sprintf("%s but its denial of existence does not prove it: %s", linkDescription,
+			err.Error()))
+	}
+	validator.chain = append(validator.chain, fmt.Sprintf("%s (proven by %s)", linkDescription, signerName))
+	return dNSSECSecure
+}
+
+// query sends a DNSSEC enabled query for the given name and type.
+func (validator *dNSSECValidator) query(name string, messageType uint16) (*dns.Msg, error) {
+	message := &dns.Msg{}
+	message.SetQuestion(dns.Fqdn(name), messageType)
+	message.RecursionDesired = true
+	prepareDNSSECMessage(message)
+	response, _, err := validator.exchange(message)
+	if err != nil {
+		return nil, err
+	}
+	if response.Rcode != dns.RcodeSuccess && response.Rcode != dns.RcodeNameError {
+		return nil, errors.New(dns.RcodeToString[response.Rcode])
+	}
+	return response, nil
+}
+
+// fail marks the validation as bogus and remembers the first failing link.
+func (validator *dNSSECValidator) fail(linkDescription string) dNSSECStatus {
+	if validator.failingLink == "" {
+		validator.failingLink = linkDescription
+	}
+	return dNSSECBogus
+}
+
+// verifyRRSet verifies the RRset with any of the signatures made by one of the given keys. It returns the signature
+// which could be verified.
+func verifyRRSet(rrset []dns.RR, signatures []*dns.RRSIG, keys []*dns.DNSKEY) (*dns.RRSIG, error) {
+	if len(signatures) == 0 {
+		return nil, errors.New("no RRSIG records found")
+	}
+	err := errors.New("no DNSKEY matches the RRSIG key tags")
+	now := time.Now()
+	for _, signature := range signatures {
+		for _, key := range keys {
+			if key.KeyTag() != signature.KeyTag || key.Algorithm != signature.Algorithm {
+				continue
+			}
+			if !signature.ValidityPeriod(now) {
+				err = fmt.Errorf("RRSIG with key tag %d is expired or not yet valid", signature.KeyTag)
+				continue
+			}
+			if verifyErr := signature.Verify(key, rrset); verifyErr != nil {
+				err = fmt.Errorf("RRSIG with key tag %d is invalid (%s)", signature.KeyTag, verifyErr.Error())
+				continue
+			}
+			return signature, nil
+		}
+	}
+	return nil, err
+}
+
+// denialRRSets returns the NSEC and NSEC3 RRsets of the given records.
+func denialRRSets(records []dns.RR) [][]dns.RR {
+	denialSets := make([][]dns.RR, 0)
+	for _, rrset := range splitRRSets(records) {
+		rrType := rrset[0].Header().Rrtype
+		if rrType == dns.TypeNSEC || rrType == dns.TypeNSEC3 {
+			denialSets = append(denialSets, rrset)
+		}
+	}
+	return denialSets
+}
+
+// splitRRSets groups the given records (except for RRSIGs) by their owner name and type.
+func splitRRSets(records []dns.RR) [][]dns.RR {
+	var rrsets [][]dns.RR
+	indexes := make(map[string]int)
+	for _, rr := range records {
+		if rr.Header().Rrtype == dns.TypeRRSIG || rr.Header().Rrtype == dns.TypeOPT {
+			continue
+		}
+		key := strings.ToLower(rr.Header().Name) + "/" + strconv.Itoa(int(rr.Header().Rrtype))
+		if index, ok := indexes[key]; ok {
+			rrsets[index] = append(rrsets[index], rr)
+			continue
+		}
+		indexes[key] = len(rrsets)
+		rrsets = append(rrsets, []dns.RR{rr})
+	}
+	return rrsets
+}
+
+// findRRSIGs returns all signatures which cover the RRset with the given owner name and type.
+func findRRSIGs(records []dns.RR, owner string, coveredType uint16) []*dns.RRSIG {
+	var signatures []*dns.RRSIG
+	for _, rr := range records {
+		if signature, ok := rr.(*dns.RRSIG); ok && signature.TypeCovered == coveredType &&
+			strings.EqualFold(signature.Hdr.Name, owner) {
+			signatures = append(signatures, signature)
+		}
+	}
+	return signatures
+}
+
+// containsRRType checks whether any of the given records has the given type.
+func containsRRType(records []dns.RR, rrType uint16) bool {
+	for _, rr := range records {
+		if rr.Header().Rrtype == rrType {
+			return true
+		}
+	}
+	return false
+}
+
+// formatDSKeyTags renders the key tags of the given DS records.
+func formatDSKeyTags(dsSet []*dns.DS) string {
+	keyTags := make([]string, len(dsSet))
+	for index, ds := range dsSet {
+		keyTags[index] = strconv.Itoa(int(ds.KeyTag))
+	}
+	return "(key tags " + strings.Join(keyTags, ", ") + ")"
+}
+
+// proveNonexistence checks whether the NSEC/NSEC3 records prove that the name does not exist (nameError) or that it has
+// no RRset of the given type (RFC 4035 section 5.4 and RFC 5155 section 8). If the name itself does not exist, the
+// records also have to prove that no wildcard at its closest encloser exists (nameError) or that the wildcard has no
+// RRset of the type.
+func proveNonexistence(records []dns.RR, name string, rrType uint16, nameError bool) error {
+	nsecs, nsec3s := denialRecords(records)
+	for _, nsec := range nsecs {
+		if strings.EqualFold(nsec.Hdr.Name, name) {
+			if nameError {
+				return fmt.Errorf("the NSEC record of %s proves that the name exists", name)
+			}
+			return checkTypeBitmap("NSEC", name, rrType, nsec.TypeBitMap)
+		}
+		if nsecCovers(nsec, name) {
+			// the NSEC record of the name before an empty non-terminal points to a name below it
+			if dns.IsSubDomain(name, nsec.NextDomain) {
+				if nameError {
+					return fmt.Errorf("the NSEC record of %s proves that %s is an empty non-terminal", nsec.Hdr.Name, name)
+				}
+				return nil
+			}
+			return proveNSECWildcard(nsecs, wildcardName(nsecClosestEncloser(nsec, name)), rrType, nameError)
+		}
+	}
+	for _, nsec3 := range nsec3s {
+		if nsec3.Match(name) {
+			if nameError {
+				return fmt.Errorf("the NSEC3 record of %s proves that the name exists", name)
+			}
+			return checkTypeBitmap("NSEC3", name, rrType, nsec3.TypeBitMap)
+		}
+	}
+	if len(nsec3s) > 0 {
+		// a missing DS record may be proven by an opt-out NSEC3 record covering the delegation
+		if !nameError && rrType == dns.TypeDS {
+			_, err := proveClosestEncloser(nsec3s, name, true)
+			return err
+		}
+		closestEncloser, err := proveClosestEncloser(nsec3s, name, false)
+		if err != nil {
+			return err
+		}
+		return proveNSEC3Wildcard(nsec3s, wildcardName(closestEncloser), rrType, nameError)
+	}
+	if nameError {
+		return fmt.Errorf("no NSEC/NSEC3 record covers %s", name)
+	}
+	return fmt.Errorf("no NSEC/NSEC3 record matches %s", name)
+}
+
+// proveUnsignedDelegation checks whether the NSEC/NSEC3 records prove that the delegation to the given name has no DS
+// RRset: the record matching the name has to list NS but not DS in its type bitmap (RFC 4035 section 5.2), or the name
+// has to be covered by an NSEC record or an opt-out NSEC3 record (RFC 5155 section 8.9).
+func proveUnsignedDelegation(records []dns.RR, name string) error {
+	nsecs, nsec3s := denialRecords(records)
+	for _, nsec := range nsecs {
+		if strings.EqualFold(nsec.Hdr.Name, name) {
+			return checkDelegationBitmap("NSEC", name, nsec.TypeBitMap)
+		}
+		if nsecCovers(nsec, name) {
+			return nil
+		}
+	}
+	for _, nsec3 := range nsec3s {
+		if nsec3.Match(name) {
+			return checkDelegationBitmap("NSEC3", name, nsec3.TypeBitMap)
+		}
+	}
+	if len(nsec3s) > 0 {
+		_, err := proveClosestEncloser(nsec3s, name, true)
+		return err
+	}
+	return fmt.Errorf("no NSEC/NSEC3 record matches or covers %s", name)
+}
+
+// proveClosestEncloser checks the closest encloser proof of the name (RFC 5155 section 8.3): an NSEC3 record has to
+// match an ancestor of the name and another one has to cover the next closer name. If optOut is set, the covering
+// record has to have the opt-out flag, which proves that the next closer name is an unsigned delegation at most. It
+// returns the proven closest encloser.
+func proveClosestEncloser(nsec3s []*dns.NSEC3, name string, optOut bool) (string, error) {
+	labelIndexes := dns.Split(name)
+	for index := 1; index < len(labelIndexes); index++ {
+		closestEncloser := name[labelIndexes[index]:]
+		if !matchesNSEC3(nsec3s, closestEncloser) {
+			continue
+		}
+		nextCloser := name[labelIndexes[index-1]:]
+		for _, nsec3 := range nsec3s {
+			if !nsec3Covers(nsec3, nextCloser) {
+				continue
+			}
+			if optOut && nsec3.Flags&nSEC3OptOut == 0 {
+				return "", fmt.Errorf("the NSEC3 record covering %s does not have the opt-out flag", nextCloser)
+			}
+			return closestEncloser, nil
+		}
+		return "", fmt.Errorf("no NSEC3 record covers %s", nextCloser)
+	}
+	return "", fmt.Errorf("no NSEC3 record matches the closest encloser of %s", name)
+}
+
+// proveWildcardExpansion checks whether the NSEC/NSEC3 records prove that no closer match than the wildcard exists for
+// a name whose answer was expanded from the wildcard with the given number of labels (RFC 4035 section 5.3.4 and
+// RFC 5155 section 8.8).
+func proveWildcardExpansion(records []dns.RR, name string, labels int) error {
+	nsecs, nsec3s := denialRecords(records)
+	for _, nsec := range nsecs {
+		if nsecCovers(nsec, name) && !dns.IsSubDomain(name, nsec.NextDomain) {
+			return nil
+		}
+	}
+	labelIndexes := dns.Split(name)
+	nextCloser := name[labelIndexes[len(labelIndexes)-labels-1]:]
+	for _, nsec3 := range nsec3s {
+		if nsec3Covers(nsec3, nextCloser) {
+			return nil
+		}
+	}
+	return fmt.Errorf("no NSEC/NSEC3 record proves that %s has no closer match", name)
+}
+
+// proveNSECWildcard checks whether the NSEC records prove that the wildcard does not exist (nameError) or that it has
+// no RRset of the given type.
+func proveNSECWildcard(nsecs []*dns.NSEC, wildcard string, rrType uint16, nameError bool) error {
+	for _, nsec := range nsecs {
+		if strings.EqualFold(nsec.Hdr.Name, wildcard) {
+			if nameError {
+				return fmt.Errorf("the NSEC record of %s proves that the wildcard exists", wildcard)
+			}
+			return checkTypeBitmap("NSEC", wildcard, rrType, nsec.TypeBitMap)
+		}
+		if nameError && nsecCovers(nsec, wildcard) {
+			return nil
+		}
+	}
+	if nameError {
+		return fmt.Errorf("no NSEC record covers the wildcard %s", wildcard)
+	}
+	return fmt.Errorf("no NSEC record matches the wildcard %s", wildcard)
+}
+
+// proveNSEC3Wildcard checks whether the NSEC3 records prove that the wildcard does not exist (nameError) or that it has
+// no RRset of the given type.
+func proveNSEC3Wildcard(nsec3s []*dns.NSEC3, wildcard string, rrType uint16, nameError bool) error {
+	for _, nsec3 := range nsec3s {
+		if nsec3.Match(wildcard) {
+			if nameError {
+				return fmt.Errorf("the NSEC3 record of %s proves that the wildcard exists", wildcard)
+			}
+			return checkTypeBitmap("NSEC3", wildcard, rrType, nsec3.TypeBitMap)
+		}
+		if nameError && nsec3Covers(nsec3, wildcard) {
+			return nil
+		}
+	}
+	if nameError {
+		return fmt.Errorf("no NSEC3 record covers the wildcard %s", wildcard)
+	}
+	return fmt.Errorf("no NSEC3 record matches the wildcard %s", wildcard)
+}
+
+// nsec3Covers checks whether the hash of the name lies strictly between the owner hash and the next hash of the NSEC3
+// record. The Cover method of the dns package also accepts the owner hash itself.
+func nsec3Covers(nsec3 *dns.NSEC3, name string) bool {
+	return nsec3.Cover(name) && !nsec3.Match(name)
+}
+
+// nsecClosestEncloser returns the closest encloser of a name covered by the NSEC record: the longest ancestor of the
+// name which the owner or the next name of the record shares (RFC 4035 section 5.4).
+func nsecClosestEncloser(nsec *dns.NSEC, name string) string {
+	commonLabels := dns.CompareDomainName(name, nsec.Hdr.Name)
+	if nextLabels := dns.CompareDomainName(name, nsec.NextDomain); nextLabels > commonLabels {
+		commonLabels = nextLabels
+	}
+	labelIndexes := dns.Split(name)
+	if commonLabels == 0 || commonLabels > len(labelIndexes) {
+		return "."
+	}
+	return name[labelIndexes[len(labelIndexes)-commonLabels]:]
+}
+
+// wildcardName returns the wildcard name directly below the given closest encloser.
+func wildcardName(closestEncloser string) string {
+	if closestEncloser == "." {
+		return "*."
+	}
+	return "*." + closestEncloser
+}
+
+// matchesNSEC3 checks whether any of the NSEC3 records matches the hash of the name.
+func matchesNSEC3(nsec3s []*dns.NSEC3, name string) bool {
+	for _, nsec3 := range nsec3s {
+		if nsec3.Match(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// denialRecords returns the NSEC and NSEC3 records of the given records.
+func denialRecords(records []dns.RR) (nsecs []*dns.NSEC, nsec3s []*dns.NSEC3) {
+	for _, rr := range records {
+		switch denial := rr.(type) {
+		case *dns.NSEC:
+			nsecs = append(nsecs, denial)
+		case *dns.NSEC3:
+			nsec3s = append(nsec3s, denial)
+		}
+	}
+	return
+}
+
+// checkTypeBitmap checks that the type bitmap of the record matching the name lists neither the type nor a CNAME.
+func checkTypeBitmap(recordType string, name string, rrType uint16, typeBitmap []uint16) error {
+	for _, listedType := range typeBitmap {
+		if listedType == rrType || listedType == dns.TypeCNAME {
+			return fmt.Errorf("the %s record of %s lists the type %s", recordType, name, dns.TypeToString[listedType])
+		}
+	}
+	return nil
+}
+
+// checkDelegationBitmap checks that the type bitmap of the record matching the name describes a delegation without a
+// DS RRset.
+func checkDelegationBitmap(recordType string, name string, typeBitmap []uint16) error {
+	hasNS := false
+	for _, listedType := range typeBitmap {
+		switch listedType {
+		case dns.TypeDS:
+			return fmt.Errorf("the %s record of %s lists a DS record", recordType, name)
+		case dns.TypeNS:
+			hasNS = true
+		}
+	}
+	if !hasNS {
+		return fmt.Errorf("the %s record of %s does not list NS records, so it is not a delegation", recordType, name)
+	}
+	return nil
+}
+
+// nsecCovers checks whether the name lies between the owner and the next name of the NSEC record in canonical order
+// (RFC 4034 section 6.1). The last NSEC record of a zone points back to the zone apex.
+func nsecCovers(nsec *dns.NSEC, name string) bool {
+	if canonicalCompare(nsec.Hdr.Name, name) >= 0 {
+		return false
+	}
+	if canonicalCompare(nsec.Hdr.Name, nsec.NextDomain) < 0 {
+		return canonicalCompare(name, nsec.NextDomain) < 0
+	}
+	return dns.IsSubDomain(nsec.NextDomain, name)
+}
+
+// canonicalCompare compares two domain names in canonical DNS name order (RFC 4034 section 6.1): label by label from
+// the rightmost label on, case insensitive.
+func canonicalCompare(first string, second string) int {
+	firstLabels := dns.SplitDomainName(strings.ToLower(first))
+	secondLabels := dns.SplitDomainName(strings.ToLower(second))
+	for index := 1; index <= len(firstLabels) && index <= len(secondLabels); index++ {
+		if comparison := strings.Compare(firstLabels[len(firstLabels)-index],
+			secondLabels[len(secondLabels)-index]); comparison != 0 {
+			return comparison
+		}
+	}
+	return len(firstLabels) - len(secondLabels)
+}
@@ -0,0 +1,161 @@
+package discord1111resolver
+
+import (
+	"github.com/miekg/dns"
+	"testing"
+)
+
+// parseTestRecords parses the given records in presentation format.
+func parseTestRecords(t *testing.T, lines ...string) []dns.RR {
+	records := make([]dns.RR, 0, len(lines))
+	for _, line := range lines {
+		record, err := dns.NewRR(line)
+		if err != nil {
+			t.Fatalf("could not parse %q: %v", line, err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestProveUnsignedDelegation(t *testing.T) {
+	hash := func(name string) string {
+		return dns.HashName(name, dns.SHA1, 0, "AB")
+	}
+	tests := []struct {
+		name    string
+		records []string
+		proven  bool
+	}{
+		{name: "matching NSEC without DS", records: []string{"example.com. 300 IN NSEC f.com. NS RRSIG NSEC"}, proven: true},
+		{name: "matching NSEC with DS", records: []string{"example.com. 300 IN NSEC f.com. NS DS RRSIG NSEC"}},
+		{name: "matching NSEC without NS", records: []string{"example.com. 300 IN NSEC f.com. A RRSIG NSEC"}},
+		{name: "covering NSEC", records: []string{"a.com. 300 IN NSEC f.com. NS DS RRSIG NSEC"}, proven: true},
+		{name: "unrelated NSEC", records: []string{"f.com. 300 IN NSEC g.com. NS RRSIG NSEC"}},
+		{name: "last NSEC of the zone", records: []string{"d.com. 300 IN NSEC com. NS RRSIG NSEC"}, proven: true},
+		{name: "matching NSEC3 without DS", records: []string{hash("example.com.") + ".com. 300 IN NSEC3 1 0 0 AB " +
+			hash("com.") + " NS RRSIG"}, proven: true},
+		{name: "matching NSEC3 with DS", records: []string{hash("example.com.") + ".com. 300 IN NSEC3 1 0 0 AB " +
+			hash("com.") + " NS DS RRSIG"}},
+		{name: "opt-out NSEC3", records: []string{
+			hash("com.") + ".com. 300 IN NSEC3 1 0 0 AB " + hash("com.") + "0 NS SOA RRSIG",
+			"00000000000000000000000000000000.com. 300 IN NSEC3 1 1 0 AB VVVVVVVVVVVVVVVVVVVVVVVVVVVVVVVV NS",
+		}, proven: true},
+		{name: "NSEC3 without opt-out", records: []string{
+			hash("com.") + ".com. 300 IN NSEC3 1 0 0 AB " + hash("com.") + "0 NS SOA RRSIG",
+			"00000000000000000000000000000000.com. 300 IN NSEC3 1 0 0 AB VVVVVVVVVVVVVVVVVVVVVVVVVVVVVVVV NS",
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := proveUnsignedDelegation(parseTestRecords(t, test.records...), "example.com.")
+			if proven := err == nil; proven != test.proven {
+				t.Errorf("expected the proof to be %t, got the error %v", test.proven, err)
+			}
+		})
+	}
+}
+
+func TestProveNonexistence(t *testing.T) {
+	records := parseTestRecords(t,
+		"example.com. 300 IN NSEC b.example.com. A NS SOA RRSIG NSEC DNSKEY",
+		"b.example.com. 300 IN NSEC x.d.example.com. A RRSIG NSEC")
+	tests := []struct {
+		name      string
+		qname     string
+		rrType    uint16
+		nameError bool
+		proven    bool
+	}{
+		{name: "NXDOMAIN covered", qname: "c.example.com.", rrType: dns.TypeA, nameError: true, proven: true},
+		{name: "NXDOMAIN not covered", qname: "z.example.com.", rrType: dns.TypeA, nameError: true},
+		{name: "NXDOMAIN for an existing name", qname: "b.example.com.", rrType: dns.TypeA, nameError: true},
+		{name: "NODATA", qname: "b.example.com.", rrType: dns.TypeAAAA, proven: true},
+		{name: "NODATA for an existing type", qname: "b.example.com.", rrType: dns.TypeA},
+		{name: "NODATA without DS", qname: "example.com.", rrType: dns.TypeDS, proven: true},
+		{name: "NODATA for an empty non-terminal", qname: "d.example.com.", rrType: dns.TypeA, proven: true},
+		{name: "NODATA for a covered name", qname: "c.example.com.", rrType: dns.TypeA},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := proveNonexistence(records, test.qname, test.rrType, test.nameError)
+			if proven := err == nil; proven != test.proven {
+				t.Errorf("expected the proof to be %t, got the error %v", test.proven, err)
+			}
+		})
+	}
+}
+
+func TestProveWildcardNonexistence(t *testing.T) {
+	hash := func(name string) string {
+		return dns.HashName(name, dns.SHA1, 0, "AB")
+	}
+	tests := []struct {
+		name      string
+		records   []string
+		rrType    uint16
+		nameError bool
+		proven    bool
+	}{
+		{name: "NXDOMAIN without wildcard proof", records: []string{"b.example.com. 300 IN NSEC x.d.example.com. A RRSIG NSEC"},
+			rrType: dns.TypeA, nameError: true},
+		{name: "NXDOMAIN with existing wildcard", records: []string{
+			"*.example.com. 300 IN NSEC d.example.com. A RRSIG NSEC"}, rrType: dns.TypeA, nameError: true},
+		{name: "wildcard NODATA", records: []string{"*.example.com. 300 IN NSEC d.example.com. A RRSIG NSEC"},
+			rrType: dns.TypeAAAA, proven: true},
+		{name: "wildcard NODATA for an existing type", records: []string{
+			"*.example.com. 300 IN NSEC d.example.com. A RRSIG NSEC"}, rrType: dns.TypeA},
+		{name: "NSEC3 NXDOMAIN", records: []string{
+			hash("example.com.") + ".example.com. 300 IN NSEC3 1 0 0 AB " + hash("example.com.") + "0 NS SOA RRSIG",
+			"00000000000000000000000000000000.example.com. 300 IN NSEC3 1 0 0 AB VVVVVVVVVVVVVVVVVVVVVVVVVVVVVVVV A",
+		}, rrType: dns.TypeA, nameError: true, proven: true},
+		{name: "NSEC3 NXDOMAIN with existing wildcard", records: []string{
+			hash("example.com.") + ".example.com. 300 IN NSEC3 1 0 0 AB " + hash("example.com.") + "0 NS SOA RRSIG",
+			hash("*.example.com.") + ".example.com. 300 IN NSEC3 1 0 0 AB " + hash("*.example.com.") + "0 A RRSIG",
+			"00000000000000000000000000000000.example.com. 300 IN NSEC3 1 0 0 AB 00000000000000000000000000000001 A",
+		}, rrType: dns.TypeA, nameError: true},
+		{name: "NSEC3 wildcard NODATA", records: []string{
+			hash("example.com.") + ".example.com. 300 IN NSEC3 1 0 0 AB " + hash("example.com.") + "0 NS SOA RRSIG",
+			hash("*.example.com.") + ".example.com. 300 IN NSEC3 1 0 0 AB " + hash("*.example.com.") + "0 A RRSIG",
+			"00000000000000000000000000000000.example.com. 300 IN NSEC3 1 0 0 AB VVVVVVVVVVVVVVVVVVVVVVVVVVVVVVVV A",
+		}, rrType: dns.TypeAAAA, proven: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := proveNonexistence(parseTestRecords(t, test.records...), "c.example.com.", test.rrType, test.nameError)
+			if proven := err == nil; proven != test.proven {
+				t.Errorf("expected the proof to be %t, got the error %v", test.proven, err)
+			}
+		})
+	}
+}
+
+func TestProveWildcardExpansion(t *testing.T) {
+	hash := func(name string) string {
+		return dns.HashName(name, dns.SHA1, 0, "AB")
+	}
+	tests := []struct {
+		name    string
+		records []string
+		proven  bool
+	}{
+		{name: "without proof"},
+		{name: "covering NSEC", records: []string{"b.example.com. 300 IN NSEC d.example.com. A RRSIG NSEC"}, proven: true},
+		{name: "unrelated NSEC", records: []string{"d.example.com. 300 IN NSEC f.example.com. A RRSIG NSEC"}},
+		{name: "NSEC of an empty non-terminal", records: []string{
+			"b.example.com. 300 IN NSEC x.c.example.com. A RRSIG NSEC"}},
+		{name: "covering NSEC3", records: []string{
+			"00000000000000000000000000000000.example.com. 300 IN NSEC3 1 0 0 AB VVVVVVVVVVVVVVVVVVVVVVVVVVVVVVVV A",
+		}, proven: true},
+		{name: "matching NSEC3", records: []string{hash("c.example.com.") + ".example.com. 300 IN NSEC3 1 0 0 AB " +
+			hash("c.example.com.") + "0 A RRSIG"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := proveWildcardExpansion(parseTestRecords(t, test.records...), "c.example.com.", 2)
+			if proven := err == nil; proven != test.proven {
+				t.Errorf("expected the proof to be %t, got the error %v", test.proven, err)
+			}
+		})
+	}
+}
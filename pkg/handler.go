@@ -5,32 +5,40 @@ import (
 	"github.com/bwmarrin/discordgo"
 	"github.com/miekg/dns"
 	"github.com/sirupsen/logrus"
+	"net"
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
+	"unicode/utf8"
 )
 
 // multipleSpaceRegex is used to trim a bot mention from Discord users.
 var multipleSpaceRegex = regexp.MustCompile(`\s+`)
 
-// allowedDNSMessageTypes contains all allowed DNS query message types (e.g. A or AAAA).
-var allowedDNSMessageTypes = map[string]uint16{
-	"A":     dns.TypeA,
-	"AAAA":  dns.TypeAAAA,
-	"CNAME": dns.TypeCNAME,
-}
-
 const (
 	// maximumValueLength is the maximum length of a discordgo Field value.
 	maximumValueLength = 1024
+	// maximumNameLength is the maximum length of a discordgo Field name.
+	maximumNameLength = 256
+	// helpContinuationFormat is used to name help fields which continue the previous field.
+	helpContinuationFormat = "%s (continued):"
 	// mentionFormat is used to check if it is a valid mention.
 	mentionFormat = "<@%s>"
 	// syntaxFormat is used to hand out a valid syntax to the Discord users.
-	syntaxFormat = "@%s <%s> <domain>"
+	syntaxFormat = "@%s [%s] [%s] <%s>[,type...] <domain> [domain...] | @%s <IP address>"
+	// reverseLookupFormat is used to describe a PTR lookup which was built from an IP address.
+	reverseLookupFormat = "Reverse lookup of `%s`."
 	// embedErrorColor is the color used for embeds which display errors/invalid formats.
 	embedErrorColor = 16007990
 	// embedSuccessColor is the color used for embeds which display a successful DNS response.
 	embedSuccessColor = 5025616
+	// successMarker marks a check or query which succeeded.
+	successMarker = ":white_check_mark:"
+	// warningMarker marks a check which succeeded but should be looked at.
+	warningMarker = ":warning:"
+	// failureMarker marks a check or query which failed.
+	failureMarker = ":x:"
 	// baseColor is the main color used on the 1.1.1.1 website.
 	baseColor = 14385742
 	// baseURL is the url where more information about the DNS service can be found.
@@ -42,6 +50,13 @@ const (
 		"This bot allows you to interact with it and execute simple requests."
 )
 
+// messageReply contains the message which is sent in response to a request of a Discord user.
+type messageReply struct {
+	*discordgo.MessageSend
+	// followUp is called after the message has been sent, e.g. to update it periodically.
+	followUp func(session *discordgo.Session, message *discordgo.Message)
+}
+
 // ResolveHandler is used to handle DNS query requests by Discord users. Its Handle method should be bound to a
 // discordgo session instance.
 type ResolveHandler struct {
@@ -53,26 +68,87 @@ type ResolveHandler struct {
 	//
 	// This allows the handler to correctly react to tags in order to fulfill its function as a DNS resolver.
 	DiscordBotUser *discordgo.User
-	// DNSClient is an instance of the miekg dns client.
+	// DNSClient is an instance of the miekg dns client. If no Upstream is set, it is used to send DNS messages over TLS
+	// to the 1.1.1.1 DNS service. Its dialer and timeout are also used for queries which are sent directly to other
+	// DNS servers (e.g. by the trace command).
 	DNSClient *dns.Client
+	// Upstream is the resolver which answers the DNS queries of Discord users.
+	Upstream Upstream
+	// Profiles contains the filtering resolvers (e.g. 1.1.1.2) which can be selected with the --profile option indexed
+	// by their profile name (see ProfileSecurity and ProfileFamily).
+	Profiles map[string]Upstream
+	// ECSUpstream is the resolver which answers queries with an EDNS Client Subnet option (--geo). It has to honour
+	// the option, which the 1.1.1.1 DNS service deliberately does not. Defaults to Upstream.
+	ECSUpstream Upstream
+	// GeoPresets contains named client subnets (e.g. eu) which can be selected with the --geo option.
+	GeoPresets map[string]*net.IPNet
+	// ComparisonResolvers contains additional resolver addresses (ip[:port]) which are queried by the compare command.
+	ComparisonResolvers []string
+	// CacheSize is the maximum number of responses to plain queries which are cached until their TTL expires. Caching is
+	// disabled if it is zero.
+	CacheSize int
+	// StaleWindow is the time expired responses are kept in the cache to answer queries while the upstream cannot be
+	// reached (RFC 8767). Stale responses are not served if it is zero.
+	StaleWindow time.Duration
+	// WatchFile is the path of the JSON file the watch subscriptions are persisted to in order to survive restarts. If
+	// it is empty, subscriptions are only kept in memory.
+	WatchFile string
 	// mentionString contains a string with the format <@DISCORD-ID> to detect request messages.
 	mentionString string
 	// syntax contains a string which represents the syntax used to execute DNS queries.
 	syntax string
+	// helpFields contains a list of all supported DNS record types and bot commands.
+	helpFields []*discordgo.MessageEmbedField
+	// cache contains the cached responses to plain queries. It is nil if caching is disabled.
+	cache *responseCache
+	// inflight collapses identical plain queries which are in flight at the same time.
+	inflight queryGroup
+	// watches contains the watch subscriptions. It is nil until StartWatches has been called.
+	watches *watchRegistry
+	// propagationRefreshes contains the propagation check messages which are currently refreshed.
+	propagationRefreshes propagationRefreshes
 }
 
 // Initialize sets basic internal values of the ResolveHandler instance and has to be called before binding the Handle
 // function.
 func (resolveHandler *ResolveHandler) Initialize() {
 	resolveHandler.mentionString = fmt.Sprintf(mentionFormat, resolveHandler.DiscordBotUser.ID)
-	// wrap allowedDNSMessageTypes to a string slice
-	availableDNSMessageTypes := make([]string, len(allowedDNSMessageTypes))
-	count := 0
-	for typeName := range allowedDNSMessageTypes {
-		availableDNSMessageTypes[count] = typeName
-		count++
-	}
-	resolveHandler.syntax = fmt.Sprintf(syntaxFormat, resolveHandler.DiscordBotUser.Username, strings.Join(availableDNSMessageTypes, "|"))
+	if resolveHandler.Upstream == nil {
+		resolveHandler.Upstream = &TLSUpstream{
+			Client:  resolveHandler.DNSClient,
+			Address: defaultUpstreamAddresses[TransportTLS],
+		}
+	}
+	if resolveHandler.ECSUpstream == nil {
+		resolveHandler.ECSUpstream = resolveHandler.Upstream
+	}
+	if resolveHandler.CacheSize > 0 {
+		resolveHandler.cache = newResponseCache(resolveHandler.CacheSize, resolveHandler.StaleWindow)
+	}
+	resolveHandler.syntax = fmt.Sprintf(syntaxFormat, resolveHandler.DiscordBotUser.Username,
+		strings.Join(queryOptionNames(), "] ["), dNSClassSyntax, strings.Join(dNSRecordTypeNames(), "|"),
+		resolveHandler.DiscordBotUser.Username)
+	resolveHandler.helpFields = append(helpFields("Supported record types:", dNSRecordTypesHelp()),
+		helpFields("Commands:", botCommandsHelp())...)
+}
+
+// helpFields distributes the help lines over as many fields as necessary to stay below the Discord field value limit.
+// Lines are never split.
+func helpFields(name string, lines []string) []*discordgo.MessageEmbedField {
+	var fields []*discordgo.MessageEmbedField
+	var value string
+	fieldName := name
+	for _, line := range lines {
+		if value != "" && utf8.RuneCountInString(value)+1+utf8.RuneCountInString(line) > maximumValueLength {
+			fields = append(fields, &discordgo.MessageEmbedField{Name: fieldName, Value: value})
+			fieldName, value = fmt.Sprintf(helpContinuationFormat, strings.TrimSuffix(name, ":")), ""
+		}
+		if value != "" {
+			value += "\n"
+		}
+		value += line
+	}
+	return append(fields, &discordgo.MessageEmbedField{Name: fieldName, Value: value})
 }
 
 // Handle handles triggered events of created messages.
@@ -97,13 +173,14 @@ func (resolveHandler *ResolveHandler) Handle(session *discordgo.Session, message
 		URL:   baseURL,
 		Color: baseColor,
 	}
-	if len(commandSplit) != 3 {
+	messageSend := &messageReply{MessageSend: &discordgo.MessageSend{Embed: messageEmbed}}
+	if len(commandSplit) < 2 {
 		goto syntaxCheck
 	}
 	// initiate params (everything after "<@DISCORD-ID> "
 	params = commandSplit[1:]
 	// handle bot mention
-	ok = resolveHandler.handleMention(messageCreate, messageEmbed, params)
+	ok = resolveHandler.handleMention(messageCreate, messageSend, params)
 	// check result
 	if ok {
 		messageEmbed.Color = embedSuccessColor
@@ -114,48 +191,87 @@ syntaxCheck:
 	var fieldsNotSet = messageEmbed.Fields == nil || len(messageEmbed.Fields) == 0
 	if fieldsNotSet {
 		messageEmbed.Description = botDescription
+		messageEmbed.Fields = resolveHandler.helpFields
 	}
-	if !ok || fieldsNotSet {
+	if (!ok || fieldsNotSet) && messageEmbed.Footer == nil {
 		messageEmbed.Footer = &discordgo.MessageEmbedFooter{Text: resolveHandler.syntax}
 	}
-	_, err := session.ChannelMessageSendEmbed(messageCreate.ChannelID, messageEmbed)
+	// move everything which does not fit into the message embed to an attachment
+	enforceEmbedLimits(messageSend.MessageSend)
+	message, err := session.ChannelMessageSendComplex(messageCreate.ChannelID, messageSend.MessageSend)
 	if err != nil {
 		logrus.WithError(err).WithField("channel-id", messageCreate.ChannelID).Warn("could not send discord message")
+		return
+	}
+	if messageSend.followUp != nil {
+		go messageSend.followUp(session, message)
 	}
 }
 
 // handleMention is an internal function which is called if the message starts with "<@DISCORD-ID> ". It returns whether
 // the execution was a success and if not, which fields should be printed within the error message.
-func (resolveHandler *ResolveHandler) handleMention(messageCreate *discordgo.MessageCreate, messageEmbed *discordgo.MessageEmbed, params []string) (ok bool) {
+func (resolveHandler *ResolveHandler) handleMention(messageCreate *discordgo.MessageCreate, messageSend *messageReply, params []string) (ok bool) {
+	messageEmbed := messageSend.Embed
+	// separate query options (e.g. +dnssec) from the remaining parameters
+	options, params, invalidOption, ok := parseQueryOptions(params)
+	if !ok {
+		trimDiscordFieldValue(&invalidOption)
+		messageEmbed.Fields = []*discordgo.MessageEmbedField{{
+			Name:   "Unknown query option:",
+			Value:  strconv.Quote(invalidOption),
+			Inline: true,
+		}}
+		return false
+	}
+	if len(params) == 0 {
+		return false
+	}
+	// check if the user wants to execute a bot command instead of a plain DNS query
+	if handled, commandOk := resolveHandler.handleCommand(messageCreate, messageSend, options, params); handled {
+		return commandOk
+	}
+	return resolveHandler.handleQuery(messageCreate, messageSend, options, params)
+}
+
+// handleQuery handles a plain DNS query with the parameters "[class] <type> <domain>" or "<IP address>". Queries with
+// several record types or domains are handled by handleBatchQuery.
+func (resolveHandler *ResolveHandler) handleQuery(messageCreate *discordgo.MessageCreate, messageSend *messageReply, options *queryOptions, params []string) (ok bool) {
+	messageEmbed := messageSend.Embed
+	// the class is optional and precedes the record type like in dig (e.g. CH TXT id.server)
+	if len(params) > 2 {
+		if class, isClass := validateDNSClass(params[0]); isClass {
+			options.class = class
+			params = params[1:]
+		}
+	}
+	// a single IP address is a shorthand for a reverse (PTR) lookup
+	if len(params) == 1 {
+		if net.ParseIP(params[0]) == nil {
+			return false
+		}
+		params = []string{"PTR", params[0]}
+	}
+	// several record types or domains are handled as a batch query
+	if isBatchQuery(params) {
+		return resolveHandler.handleBatchQuery(messageCreate, messageSend, options, params)
+	}
 	// check params length
 	if len(params) != 2 {
 		return false
 	}
 	// validate the DNS message type parameter
-	var messageType uint16
-	messageTypeString := params[0]
-	messageType, ok = validateDNSMessageType(messageTypeString)
+	recordType, ok := validateDNSMessageTypeParam(messageEmbed, params[0])
 	if !ok {
-		trimDiscordFieldValue(&messageTypeString)
-		// the user specified an invalid DNS message type
-		messageEmbed.Fields = []*discordgo.MessageEmbedField{{
-			Name:   "Invalid DNS message type:",
-			Value:  strconv.Quote(messageTypeString),
-			Inline: true,
-		}}
 		return false
 	}
 	// validate the domain name
 	domainName := params[1]
-	_, ok = dns.IsDomainName(domainName)
-	if !ok {
-		trimDiscordFieldValue(&domainName)
-		// the user specified an invalid domain name
-		messageEmbed.Fields = []*discordgo.MessageEmbedField{{
-			Name:   "Invalid domain name:",
-			Value:  strconv.Quote(domainName),
-			Inline: true,
-		}}
+	if ip := net.ParseIP(domainName); ip != nil {
+		if domainName, ok = reverseDomainName(messageEmbed, recordType, ip); !ok {
+			return false
+		}
+	}
+	if ok = validateDomainName(messageEmbed, domainName); !ok {
 		return false
 	}
 	var shortenedDomainName string
@@ -166,19 +282,73 @@ func (resolveHandler *ResolveHandler) handleMention(messageCreate *discordgo.Mes
 		logrus.WithField("domain-name", shortenedDomainName).WithField("channel-id", messageCreate.ChannelID).
 			WithField("message-id", messageCreate.ID).Debug("requesting DNS entry...")
 	}
-	ok = resolveHandler.executeDNSRequest(messageEmbed, messageType, messageTypeString, domainName)
+	ok = resolveHandler.executeDNSRequest(messageSend, recordType.messageType, recordType.name, domainName, options)
 	if logrus.GetLevel() > logrus.DebugLevel {
 		logrus.WithField("id", messageCreate.ID).WithField("ok", ok).Debug("result of DNS request.")
 	}
 	return
 }
 
-func validateDNSMessageType(messageTypeString string) (messageType uint16, ok bool) {
+// reverseDomainName builds the in-addr.arpa or ip6.arpa name of the given IP address. It fails if the user requested a
+// record type other than PTR because querying an address as a plain domain name does not make any sense.
+func reverseDomainName(messageEmbed *discordgo.MessageEmbed, recordType *dNSRecordType, ip net.IP) (domainName string, ok bool) {
+	if recordType.messageType != dns.TypePTR {
+		messageEmbed.Fields = []*discordgo.MessageEmbedField{{
+			Name:   "IP addresses can only be resolved with the PTR record type:",
+			Value:  strconv.Quote(ip.String()),
+			Inline: true,
+		}}
+		return "", false
+	}
+	domainName, err := dns.ReverseAddr(ip.String())
+	if err != nil {
+		logrus.WithError(err).Warn("could not build reverse domain name")
+		messageEmbed.Fields = []*discordgo.MessageEmbedField{{
+			Name:   "An error occurred while building the reverse domain name:",
+			Value:  strconv.Quote(err.Error()),
+			Inline: true,
+		}}
+		return "", false
+	}
+	messageEmbed.Description = fmt.Sprintf(reverseLookupFormat, ip.String())
+	return domainName, true
+}
+
+// validateDomainName checks whether the user specified a valid domain name and sets an error field if not.
+func validateDomainName(messageEmbed *discordgo.MessageEmbed, domainName string) (ok bool) {
+	if _, ok = dns.IsDomainName(domainName); !ok {
+		trimDiscordFieldValue(&domainName)
+		// the user specified an invalid domain name
+		messageEmbed.Fields = []*discordgo.MessageEmbedField{{
+			Name:   "Invalid domain name:",
+			Value:  strconv.Quote(domainName),
+			Inline: true,
+		}}
+	}
+	return
+}
+
+func validateDNSMessageType(messageTypeString string) (recordType *dNSRecordType, ok bool) {
 	messageTypeString = strings.ToUpper(messageTypeString)
-	messageType, ok = allowedDNSMessageTypes[messageTypeString]
+	recordType, ok = allowedDNSMessageTypes[messageTypeString]
 	return
 }
 
+// validateDNSMessageTypeParam validates the DNS message type specified by the user and sets an error field if it is
+// invalid.
+func validateDNSMessageTypeParam(messageEmbed *discordgo.MessageEmbed, messageTypeString string) (recordType *dNSRecordType, ok bool) {
+	if recordType, ok = validateDNSMessageType(messageTypeString); ok {
+		return recordType, true
+	}
+	trimDiscordFieldValue(&messageTypeString)
+	messageEmbed.Fields = []*discordgo.MessageEmbedField{{
+		Name:   "Invalid DNS message type:",
+		Value:  strconv.Quote(messageTypeString),
+		Inline: true,
+	}}
+	return nil, false
+}
+
 func trimDiscordFieldValue(value *string) {
 	if len(*value) > maximumValueLength {
 		*value = (*value)[:maximumValueLength-3] + "..."
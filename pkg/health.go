@@ -0,0 +1,395 @@
+package discord1111resolver
+
+import (
+	"fmt"
+	"github.com/bwmarrin/discordgo"
+	"github.com/miekg/dns"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// healthDurationFormat is used to summarize a health check in the embed footer.
+	healthDurationFormat = "Checked %d addresses of %d name servers in %v."
+	// healthServerFormat is used to render the result of a single name server address.
+	healthServerFormat = "%s %s %s"
+)
+
+// healthResult contains the answers of a single name server address.
+type healthResult struct {
+	// server is the name of the name server.
+	server string
+	// address is the IP address which was queried.
+	address string
+	// duration is the time the name server needed to answer the SOA query.
+	duration time.Duration
+	// err contains the error which occurred while querying the name server.
+	err error
+	// rcode is the response code of the SOA query.
+	rcode int
+	// authoritative is true if the name server set the AA flag on both answers.
+	authoritative bool
+	// soa is the SOA record returned by the name server.
+	soa *dns.SOA
+	// nameServers contains the sorted NS set returned by the name server.
+	nameServers []string
+}
+
+func (resolveHandler *ResolveHandler) handleHealthCommand(messageCreate *discordgo.MessageCreate,
+	messageSend *messageReply, options *queryOptions, params []string) (ok bool) {
+	messageEmbed := messageSend.Embed
+	if len(params) != 1 {
+		return false
+	}
+	zone, ok := prepareDomainName(messageEmbed, params[0])
+	if !ok {
+		return false
+	}
+	start := time.Now()
+	// the delegation (NS set and glue) is taken from the parent zone, which is found by tracing from the root
+	hops := resolveHandler.trace(zone, dns.TypeSOA)
+	var referral *traceHop
+	for _, hop := range hops {
+		if strings.EqualFold(hop.referralZone, zone) {
+			referral = hop
+		}
+	}
+	if referral == nil {
+		lastHop := formatTraceHop(hops[len(hops)-1])
+		trimDiscordFieldValue(&lastHop)
+		messageEmbed.Fields = []*discordgo.MessageEmbedField{{
+			Name:  fmt.Sprintf("Could not find the delegation of %s:", zone),
+			Value: lastHop,
+		}}
+		return false
+	}
+	parentNameServers := make([]string, 0, len(referral.referralServers))
+	for _, server := range referral.referralServers {
+		parentNameServers = append(parentNameServers, strings.ToLower(server.name))
+	}
+	sort.Strings(parentNameServers)
+	glue := make(map[string][]string)
+	for _, extra := range referral.response.Extra {
+		switch glueRecord := extra.(type) {
+		case *dns.A:
+			glue[strings.ToLower(glueRecord.Hdr.Name)] = append(glue[strings.ToLower(glueRecord.Hdr.Name)], glueRecord.A.String())
+		case *dns.AAAA:
+			glue[strings.ToLower(glueRecord.Hdr.Name)] = append(glue[strings.ToLower(glueRecord.Hdr.Name)], glueRecord.AAAA.String())
+		}
+	}
+	// the child NS set is what the authoritative name servers serve, not what a (caching) resolver returns
+	results, unresolvableServers := resolveHandler.queryNameServers(zone, parentNameServers, glue)
+	childNameServers := childNameServerSet(results)
+	if childOnlyServers := subtractNames(childNameServers, parentNameServers); len(childOnlyServers) > 0 {
+		childResults, unresolvableChildServers := resolveHandler.queryNameServers(zone, childOnlyServers, glue)
+		results = append(results, childResults...)
+		unresolvableServers = append(unresolvableServers, unresolvableChildServers...)
+		sort.SliceStable(results, func(i, j int) bool {
+			return results[i].server < results[j].server
+		})
+	}
+	serverNames := mergeSortedNames(parentNameServers, childNameServers)
+	messageEmbed.Description = fmt.Sprintf("Delegation health check of `%s` (delegated by `%s`).", zone, referral.zone)
+	lines := make([]string, len(results))
+	for index, result := range results {
+		lines[index] = formatHealthResult(result)
+	}
+	messageEmbed.Fields = []*discordgo.MessageEmbedField{verboseSectionField("Name servers:", lines)}
+	problems := []struct {
+		name  string
+		lines []string
+	}{
+		{name: "Lame delegations:", lines: findLameDelegations(results, unresolvableServers)},
+		{name: "Non-authoritative answers:", lines: findNonAuthoritativeAnswers(results)},
+		{name: "Serial mismatch:", lines: findSerialMismatches(results)},
+		{name: "Missing glue:", lines: findMissingGlue(zone, parentNameServers, glue)},
+		{name: "NS set differences:", lines: findNameServerDifferences(parentNameServers, childNameServers, results)},
+	}
+	ok = true
+	for _, problem := range problems {
+		if len(problem.lines) > 0 {
+			ok = false
+			messageEmbed.Fields = append(messageEmbed.Fields, verboseSectionField(problem.name, problem.lines))
+		}
+	}
+	// the bot host may not have IPv6 connectivity, so these failures do not count as problems of the zone
+	if lines := findIPv6Failures(results); len(lines) > 0 {
+		messageEmbed.Fields = append(messageEmbed.Fields, verboseSectionField("IPv6 query failures:", lines))
+	}
+	if ok {
+		messageEmbed.Fields = append(messageEmbed.Fields, &discordgo.MessageEmbedField{
+			Name:   "Result:",
+			Value:  successMarker + " No problems found.",
+			Inline: true,
+		})
+	}
+	messageEmbed.Footer = &discordgo.MessageEmbedFooter{
+		Text: fmt.Sprintf(healthDurationFormat, len(results), len(serverNames), time.Since(start)),
+	}
+	return ok
+}
+
+// lookupNameServers returns the sorted NS set of the zone as seen by the configured upstream resolver.
+func (resolveHandler *ResolveHandler) lookupNameServers(zone string) ([]string, error) {
+	records, err := resolveHandler.lookupRecords(zone, dns.TypeNS)
+	if err != nil {
+		return nil, err
+	}
+	return sortedNameServers(records), nil
+}
+
+// childNameServerSet returns the NS set most name servers agree on in their authoritative answers.
+func childNameServerSet(results []*healthResult) []string {
+	votes := make(map[string]int)
+	var nameServers []string
+	for _, result := range results {
+		if result.err != nil || result.rcode != dns.RcodeSuccess || !result.authoritative || len(result.nameServers) == 0 {
+			continue
+		}
+		key := strings.Join(result.nameServers, " ")
+		votes[key]++
+		if votes[key] > votes[strings.Join(nameServers, " ")] {
+			nameServers = result.nameServers
+		}
+	}
+	return nameServers
+}
+
+// queryNameServers resolves the addresses of every name server (glue addresses are used as well) and queries every
+// address directly for the SOA and NS records of the zone. Name servers without any address are returned separately.
+func (resolveHandler *ResolveHandler) queryNameServers(zone string, serverNames []string, glue map[string][]string) (results []*healthResult, unresolvableServers []string) {
+	for _, serverName := range serverNames {
+		addresses := append([]string{}, glue[serverName]...)
+		for _, messageType := range []uint16{dns.TypeA, dns.TypeAAAA} {
+			records, err := resolveHandler.lookupRecords(serverName, messageType)
+			if err != nil {
+				continue
+			}
+			for _, record := range records {
+				switch addressRecord := record.(type) {
+				case *dns.A:
+					addresses = append(addresses, addressRecord.A.String())
+				case *dns.AAAA:
+					addresses = append(addresses, addressRecord.AAAA.String())
+				}
+			}
+		}
+		addresses = mergeSortedNames(addresses)
+		if len(addresses) == 0 {
+			unresolvableServers = append(unresolvableServers, serverName)
+		}
+		for _, address := range addresses {
+			results = append(results, &healthResult{server: serverName, address: address})
+		}
+	}
+	waitGroup := &sync.WaitGroup{}
+	for _, result := range results {
+		waitGroup.Add(1)
+		go func(result *healthResult) {
+			defer waitGroup.Done()
+			resolveHandler.queryNameServer(zone, result)
+		}(result)
+	}
+	waitGroup.Wait()
+	return results, unresolvableServers
+}
+
+// queryNameServer asks a single name server address for the SOA and NS records of the zone.
+func (resolveHandler *ResolveHandler) queryNameServer(zone string, result *healthResult) {
+	address := net.JoinHostPort(result.address, "53")
+	message := &dns.Msg{}
+	message.SetQuestion(zone, dns.TypeSOA)
+	message.RecursionDesired = false
+	response, duration, err := resolveHandler.exchangeDirect(message, address)
+	if err != nil {
+		result.err = err
+		return
+	}
+	result.duration, result.rcode, result.authoritative = duration, response.Rcode, response.Authoritative
+	for _, answer := range response.Answer {
+		if soa, isSOA := answer.(*dns.SOA); isSOA {
+			result.soa = soa
+		}
+	}
+	message = &dns.Msg{}
+	message.SetQuestion(zone, dns.TypeNS)
+	message.RecursionDesired = false
+	if response, _, err = resolveHandler.exchangeDirect(message, address); err != nil {
+		result.err = err
+		return
+	}
+	result.authoritative = result.authoritative && response.Authoritative
+	result.nameServers = sortedNameServers(response.Answer)
+}
+
+// formatHealthResult renders the result of a single name server address.
+func formatHealthResult(result *healthResult) string {
+	var status string
+	switch {
+	case result.err != nil:
+		status = "error: " + result.err.Error()
+	case result.rcode != dns.RcodeSuccess:
+		status, _ = validateDNSResponseCode(result.rcode)
+	case result.soa == nil:
+		status = "no SOA record"
+	case result.authoritative:
+		status = fmt.Sprintf("serial %d, aa, %s", result.soa.Serial, formatMilliseconds(result.duration))
+	default:
+		status = fmt.Sprintf("serial %d, not authoritative, %s", result.soa.Serial, formatMilliseconds(result.duration))
+	}
+	return fmt.Sprintf(healthServerFormat, result.server, result.address, status)
+}
+
+// findLameDelegations returns the name servers which could not be resolved or do not answer for the zone.
+func findLameDelegations(results []*healthResult, unresolvableServers []string) []string {
+	var lines []string
+	for _, serverName := range unresolvableServers {
+		lines = append(lines, serverName+" has no IP address")
+	}
+	for _, result := range results {
+		switch {
+		case result.err != nil && isIPv6Address(result.address):
+			// reported by findIPv6Failures
+		case result.err != nil:
+			lines = append(lines, fmt.Sprintf("%s (%s) is unreachable", result.server, result.address))
+		case result.rcode != dns.RcodeSuccess:
+			errorMessage, _ := validateDNSResponseCode(result.rcode)
+			lines = append(lines, fmt.Sprintf("%s (%s) answers with %s", result.server, result.address, errorMessage))
+		case result.soa == nil:
+			lines = append(lines, fmt.Sprintf("%s (%s) does not serve the zone", result.server, result.address))
+		}
+	}
+	return lines
+}
+
+// findIPv6Failures returns the name server IPv6 addresses which could not be queried.
+func findIPv6Failures(results []*healthResult) []string {
+	var lines []string
+	for _, result := range results {
+		if result.err != nil && isIPv6Address(result.address) {
+			lines = append(lines, fmt.Sprintf("%s (%s): %s", result.server, result.address, result.err.Error()))
+		}
+	}
+	return lines
+}
+
+// isIPv6Address checks whether the address is an IPv6 address.
+func isIPv6Address(address string) bool {
+	ip := net.ParseIP(address)
+	return ip != nil && ip.To4() == nil
+}
+
+// findNonAuthoritativeAnswers returns the name servers which answered without setting the AA flag.
+func findNonAuthoritativeAnswers(results []*healthResult) []string {
+	var lines []string
+	for _, result := range results {
+		if result.err == nil && result.soa != nil && !result.authoritative {
+			lines = append(lines, fmt.Sprintf("%s (%s) did not set the AA flag", result.server, result.address))
+		}
+	}
+	return lines
+}
+
+// findSerialMismatches returns the SOA serials and the name servers which returned them if they differ.
+func findSerialMismatches(results []*healthResult) []string {
+	serverNamesBySerial := make(map[uint32][]string)
+	var serials []uint32
+	for _, result := range results {
+		if result.err != nil || result.soa == nil {
+			continue
+		}
+		if _, found := serverNamesBySerial[result.soa.Serial]; !found {
+			serials = append(serials, result.soa.Serial)
+		}
+		serverNamesBySerial[result.soa.Serial] = append(serverNamesBySerial[result.soa.Serial],
+			fmt.Sprintf("%s (%s)", result.server, result.address))
+	}
+	if len(serials) < 2 {
+		return nil
+	}
+	lines := make([]string, len(serials))
+	for index, serial := range serials {
+		lines[index] = fmt.Sprintf("%d: %s", serial, strings.Join(serverNamesBySerial[serial], ", "))
+	}
+	return lines
+}
+
+// findMissingGlue returns the name servers within the zone which were delegated without glue addresses.
+func findMissingGlue(zone string, parentNameServers []string, glue map[string][]string) []string {
+	var lines []string
+	for _, serverName := range parentNameServers {
+		if dns.IsSubDomain(zone, serverName) && len(glue[serverName]) == 0 {
+			lines = append(lines, serverName+" is within the zone but the parent has no glue addresses")
+		}
+	}
+	return lines
+}
+
+// findNameServerDifferences compares the NS set of the parent zone, of the child zone and of every name server. The
+// child NS set is unknown if no name server answered authoritatively.
+func findNameServerDifferences(parentNameServers []string, childNameServers []string, results []*healthResult) []string {
+	if len(childNameServers) == 0 {
+		return nil
+	}
+	var lines []string
+	if parentOnly := subtractNames(parentNameServers, childNameServers); len(parentOnly) > 0 {
+		lines = append(lines, "only in the parent zone: "+strings.Join(parentOnly, ", "))
+	}
+	if childOnly := subtractNames(childNameServers, parentNameServers); len(childOnly) > 0 {
+		lines = append(lines, "only in the child zone: "+strings.Join(childOnly, ", "))
+	}
+	childNameServerSet := strings.Join(childNameServers, " ")
+	for _, result := range results {
+		if result.err == nil && result.soa != nil && strings.Join(result.nameServers, " ") != childNameServerSet {
+			lines = append(lines, fmt.Sprintf("%s (%s) returns the NS set %s", result.server, result.address,
+				strings.Join(result.nameServers, ", ")))
+		}
+	}
+	return lines
+}
+
+// sortedNameServers returns the sorted and lowercased targets of the NS records.
+func sortedNameServers(records []dns.RR) []string {
+	var nameServers []string
+	for _, record := range records {
+		if ns, isNS := record.(*dns.NS); isNS {
+			nameServers = append(nameServers, strings.ToLower(ns.Ns))
+		}
+	}
+	sort.Strings(nameServers)
+	return nameServers
+}
+
+// mergeSortedNames returns the sorted union of the given names without duplicates.
+func mergeSortedNames(nameLists ...[]string) []string {
+	seenNames := make(map[string]bool)
+	var names []string
+	for _, nameList := range nameLists {
+		for _, name := range nameList {
+			if !seenNames[name] {
+				seenNames[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// subtractNames returns the names which are part of the first list but not of the second one.
+func subtractNames(names []string, excludedNames []string) []string {
+	excluded := make(map[string]bool, len(excludedNames))
+	for _, name := range excludedNames {
+		excluded[name] = true
+	}
+	var remainingNames []string
+	for _, name := range names {
+		if !excluded[name] {
+			remainingNames = append(remainingNames, name)
+		}
+	}
+	return remainingNames
+}
@@ -0,0 +1,407 @@
+package discord1111resolver
+
+import (
+	"fmt"
+	"github.com/bwmarrin/discordgo"
+	"github.com/miekg/dns"
+	"sort"
+	"strings"
+)
+
+const (
+	// maximumSPFLookups is the maximum number of DNS lookups an SPF evaluation may cause (RFC 7208 section 4.6.4).
+	maximumSPFLookups = 10
+	// maximumDKIMSelectors is the maximum number of DKIM selectors which are probed by a single mail command.
+	maximumDKIMSelectors = 5
+	// sPFVersion is the version tag every SPF record starts with.
+	sPFVersion = "v=spf1"
+	// dMARCVersion is the version tag every DMARC record starts with.
+	dMARCVersion = "v=DMARC1"
+	// mTASTSVersion is the version tag every MTA-STS record starts with.
+	mTASTSVersion = "v=STSv1"
+	// tLSRPTVersion is the version tag every SMTP TLS reporting record starts with.
+	tLSRPTVersion = "v=TLSRPTv1"
+	// mailReportFormat is used to describe the mail report.
+	mailReportFormat = "Email deliverability report for `%s`."
+)
+
+// defaultDKIMSelectors contains commonly used DKIM selectors which are probed if the user did not specify any.
+var defaultDKIMSelectors = []string{"default", "google", "selector1", "selector2", "k1", "mail", "dkim"}
+
+// mailCheck contains the result of a single check of the mail command.
+type mailCheck struct {
+	// name is the name of the check (e.g. SPF).
+	name string
+	// marker is one of successMarker, warningMarker or failureMarker.
+	marker string
+	// details describes the result of the check.
+	details []string
+}
+
+// sPFExpansion contains the state of a recursive SPF expansion.
+type sPFExpansion struct {
+	// lookups is the number of mechanisms and modifiers which cause DNS lookups.
+	lookups int
+	// tree contains every expanded domain indented by its depth.
+	tree []string
+	// problems contains every problem found while expanding the records.
+	problems []string
+	// path contains the domains on the current expansion path to detect loops.
+	path map[string]bool
+}
+
+func (resolveHandler *ResolveHandler) handleMailCommand(messageCreate *discordgo.MessageCreate,
+	messageSend *messageReply, options *queryOptions, params []string) (ok bool) {
+	messageEmbed := messageSend.Embed
+	if len(params) < 1 || len(params) > 1+maximumDKIMSelectors {
+		return false
+	}
+	domain, ok := prepareDomainName(messageEmbed, params[0])
+	if !ok {
+		return false
+	}
+	checks := []*mailCheck{
+		resolveHandler.checkMX(domain),
+		resolveHandler.checkSPF(domain),
+		resolveHandler.checkDMARC(domain),
+		resolveHandler.checkDKIM(domain, params[1:]),
+		resolveHandler.checkPolicyRecord("MTA-STS:", "_mta-sts."+domain, mTASTSVersion),
+		resolveHandler.checkPolicyRecord("SMTP TLS reporting:", "_smtp._tls."+domain, tLSRPTVersion),
+	}
+	messageEmbed.Description = fmt.Sprintf(mailReportFormat, domain)
+	messageEmbed.Fields = make([]*discordgo.MessageEmbedField, len(checks))
+	ok = true
+	for index, check := range checks {
+		if check.marker == failureMarker {
+			ok = false
+		}
+		value := check.marker + " " + strings.Join(check.details, "\n")
+		trimDiscordFieldValue(&value)
+		messageEmbed.Fields[index] = &discordgo.MessageEmbedField{
+			Name:  check.name,
+			Value: value,
+		}
+	}
+	messageEmbed.Footer = &discordgo.MessageEmbedFooter{Text: fmt.Sprintf("Queried via %s.", resolveHandler.Upstream.String())}
+	return ok
+}
+
+// checkMX checks whether the domain has mail exchangers.
+func (resolveHandler *ResolveHandler) checkMX(domain string) *mailCheck {
+	check := &mailCheck{name: "MX:", marker: failureMarker}
+	records, err := resolveHandler.lookupRecords(domain, dns.TypeMX)
+	if err != nil {
+		check.details = []string{err.Error()}
+		return check
+	}
+	var mxRecords []*dns.MX
+	for _, record := range records {
+		if mx, isMX := record.(*dns.MX); isMX {
+			mxRecords = append(mxRecords, mx)
+		}
+	}
+	if len(mxRecords) == 0 {
+		check.details = []string{"No MX records, mail is delivered to the A/AAAA records of the domain (if any)."}
+		return check
+	}
+	sort.Slice(mxRecords, func(i, j int) bool {
+		return mxRecords[i].Preference < mxRecords[j].Preference
+	})
+	// a single MX record with the root as target is a null MX (RFC 7505)
+	if len(mxRecords) == 1 && mxRecords[0].Mx == "." {
+		check.marker = warningMarker
+		check.details = []string{"Null MX: the domain explicitly does not accept mail."}
+		return check
+	}
+	check.marker = successMarker
+	for _, mx := range mxRecords {
+		check.details = append(check.details, fmt.Sprintf("`%d %s`", mx.Preference, mx.Mx))
+	}
+	return check
+}
+
+// checkSPF looks up the SPF record of the domain and expands it recursively while counting the DNS lookups.
+func (resolveHandler *ResolveHandler) checkSPF(domain string) *mailCheck {
+	check := &mailCheck{name: "SPF:", marker: failureMarker}
+	expansion := &sPFExpansion{path: make(map[string]bool)}
+	record, all := resolveHandler.expandSPF(expansion, domain, 0)
+	if record == "" {
+		check.details = expansion.problems
+		return check
+	}
+	check.details = []string{fmt.Sprintf("`%s`", record),
+		fmt.Sprintf("%d/%d DNS lookups", expansion.lookups, maximumSPFLookups)}
+	switch all {
+	case "-":
+		check.marker = successMarker
+		check.details = append(check.details, "Unlisted senders fail (`-all`).")
+	case "~":
+		check.marker = successMarker
+		check.details = append(check.details, "Unlisted senders soft fail (`~all`).")
+	case "?":
+		check.marker = warningMarker
+		check.details = append(check.details, "Unlisted senders are neutral (`?all`).")
+	case "+":
+		check.details = append(check.details, "Every sender is allowed (`+all`).")
+		return check
+	default:
+		check.marker = warningMarker
+		check.details = append(check.details, "The record does not end with an `all` mechanism.")
+	}
+	if expansion.lookups > maximumSPFLookups {
+		check.marker = failureMarker
+		check.details = append(check.details, fmt.Sprintf("The record exceeds the limit of %d DNS lookups and results in a permanent error.", maximumSPFLookups))
+	}
+	if len(expansion.problems) > 0 {
+		check.marker = failureMarker
+		check.details = append(check.details, expansion.problems...)
+	}
+	if len(expansion.tree) > 1 {
+		check.details = append(check.details, formatCodeBlock(expansion.tree))
+	}
+	return check
+}
+
+// expandSPF looks up the SPF record of the domain and follows its include mechanisms and redirect modifier. It returns
+// the record and the qualifier of its all mechanism (or of the all mechanism of the redirect target).
+func (resolveHandler *ResolveHandler) expandSPF(expansion *sPFExpansion, domain string, depth int) (record string, all string) {
+	expansion.path[strings.ToLower(domain)] = true
+	defer delete(expansion.path, strings.ToLower(domain))
+	expansion.tree = append(expansion.tree, strings.Repeat("  ", depth)+domain)
+	records, err := resolveHandler.lookupTXT(domain)
+	if err != nil {
+		expansion.problems = append(expansion.problems, fmt.Sprintf("Could not look up the SPF record of `%s`: %s", domain, err.Error()))
+		return "", ""
+	}
+	var sPFRecords []string
+	for _, txt := range records {
+		if strings.EqualFold(txt, sPFVersion) || strings.HasPrefix(strings.ToLower(txt), sPFVersion+" ") {
+			sPFRecords = append(sPFRecords, txt)
+		}
+	}
+	switch len(sPFRecords) {
+	case 0:
+		expansion.problems = append(expansion.problems, fmt.Sprintf("`%s` has no SPF record.", domain))
+		return "", ""
+	case 1:
+	default:
+		expansion.problems = append(expansion.problems, fmt.Sprintf("`%s` has %d SPF records (exactly one is allowed).", domain, len(sPFRecords)))
+		return "", ""
+	}
+	record = sPFRecords[0]
+	var redirect string
+	for _, term := range strings.Fields(record)[1:] {
+		lowerTerm := strings.ToLower(term)
+		if strings.HasPrefix(lowerTerm, "redirect=") {
+			redirect = term[len("redirect="):]
+			continue
+		}
+		qualifier := "+"
+		if strings.IndexAny(lowerTerm[:1], "+-~?") == 0 {
+			qualifier, lowerTerm, term = lowerTerm[:1], lowerTerm[1:], term[1:]
+		}
+		mechanism := strings.SplitN(strings.SplitN(lowerTerm, ":", 2)[0], "/", 2)[0]
+		switch mechanism {
+		case "all":
+			all = qualifier
+		case "include":
+			expansion.lookups++
+			if nameAndTarget := strings.SplitN(term, ":", 2); len(nameAndTarget) == 2 {
+				resolveHandler.expandSPFTarget(expansion, nameAndTarget[1], depth)
+			}
+		case "a", "mx", "ptr", "exists":
+			expansion.lookups++
+		}
+	}
+	// the redirect modifier is ignored if the record contains an all mechanism
+	if redirect != "" && all == "" {
+		expansion.lookups++
+		all = resolveHandler.expandSPFTarget(expansion, redirect, depth)
+	}
+	return record, all
+}
+
+// expandSPFTarget expands the SPF record of an include or redirect target unless it contains macros, is already on the
+// expansion path (a loop) or the lookup limit is exceeded. A target which is included more than once without a loop is
+// expanded again because its lookups count every time.
+func (resolveHandler *ResolveHandler) expandSPFTarget(expansion *sPFExpansion, target string, depth int) (all string) {
+	switch {
+	case strings.Contains(target, "%"):
+		expansion.tree = append(expansion.tree, strings.Repeat("  ", depth+1)+target+" (macro, not expanded)")
+	case expansion.path[strings.ToLower(dns.Fqdn(target))]:
+		expansion.problems = append(expansion.problems, fmt.Sprintf("`%s` includes itself (loop).", target))
+	case expansion.lookups > maximumSPFLookups:
+	default:
+		_, all = resolveHandler.expandSPF(expansion, dns.Fqdn(target), depth+1)
+	}
+	return all
+}
+
+// checkDMARC looks up the DMARC policy of the domain.
+func (resolveHandler *ResolveHandler) checkDMARC(domain string) *mailCheck {
+	check := &mailCheck{name: "DMARC:", marker: failureMarker}
+	record, err := resolveHandler.lookupPolicyRecord("_dmarc."+domain, dMARCVersion)
+	switch {
+	case err != nil:
+		check.details = []string{err.Error()}
+		return check
+	case record == "":
+		check.details = []string{fmt.Sprintf("`_dmarc.%s` has no DMARC record.", domain)}
+		return check
+	}
+	tags := parseTagList(record)
+	check.details = []string{fmt.Sprintf("`%s`", record)}
+	switch strings.ToLower(tags["p"]) {
+	case "reject", "quarantine":
+		check.marker = successMarker
+		check.details = append(check.details, fmt.Sprintf("Failing mail is handled with policy `%s`.", tags["p"]))
+	case "none":
+		check.marker = warningMarker
+		check.details = append(check.details, "The policy `none` only monitors, failing mail is still delivered.")
+	default:
+		check.details = append(check.details, "The record has no valid policy (p= tag).")
+		return check
+	}
+	if percentage, found := tags["pct"]; found && percentage != "100" {
+		check.marker = warningMarker
+		check.details = append(check.details, fmt.Sprintf("The policy is only applied to %s%% of failing mail.", percentage))
+	}
+	if _, found := tags["rua"]; !found {
+		check.details = append(check.details, "No aggregate reports are requested (rua= tag).")
+	}
+	return check
+}
+
+// checkDKIM probes the given DKIM selectors (or commonly used ones if none are given) for public keys.
+func (resolveHandler *ResolveHandler) checkDKIM(domain string, selectors []string) *mailCheck {
+	check := &mailCheck{name: "DKIM:", marker: failureMarker}
+	probeDefaults := len(selectors) == 0
+	if probeDefaults {
+		selectors = defaultDKIMSelectors
+	}
+	check.marker = successMarker
+	for _, selector := range selectors {
+		marker, detail := resolveHandler.probeDKIMSelector(domain, selector)
+		if marker == "" {
+			// selectors which were guessed are only listed if they exist
+			if probeDefaults {
+				continue
+			}
+			marker, detail = failureMarker, "no key published"
+		}
+		if marker == failureMarker || (marker == warningMarker && check.marker == successMarker) {
+			check.marker = marker
+		}
+		check.details = append(check.details, fmt.Sprintf("%s `%s`: %s", marker, selector, detail))
+	}
+	if len(check.details) == 0 {
+		check.marker = warningMarker
+		check.details = []string{fmt.Sprintf("None of the common selectors (%s) has a key, pass the selectors as parameters.",
+			strings.Join(defaultDKIMSelectors, ", "))}
+		return check
+	}
+	check.details = append([]string{"Selectors:"}, check.details...)
+	return check
+}
+
+// probeDKIMSelector looks up the DKIM key of the given selector. The marker is empty if no key is published.
+func (resolveHandler *ResolveHandler) probeDKIMSelector(domain string, selector string) (marker string, detail string) {
+	records, err := resolveHandler.lookupTXT(selector + "._domainkey." + domain)
+	if err != nil {
+		return failureMarker, err.Error()
+	}
+	if len(records) == 0 {
+		return "", ""
+	}
+	tags := parseTagList(strings.Join(records, ""))
+	publicKey, hasKey := tags["p"]
+	switch {
+	case !hasKey:
+		return failureMarker, "the record contains no public key (p= tag)"
+	case publicKey == "":
+		return warningMarker, "the key is revoked (empty p= tag)"
+	}
+	keyType := tags["k"]
+	if keyType == "" {
+		keyType = "rsa"
+	}
+	return successMarker, keyType + " key published"
+}
+
+// checkPolicyRecord checks whether the given name has a TXT record with the given version tag (e.g. MTA-STS). Missing
+// records only result in a warning because these policies are optional.
+func (resolveHandler *ResolveHandler) checkPolicyRecord(checkName string, name string, version string) *mailCheck {
+	check := &mailCheck{name: checkName, marker: failureMarker}
+	record, err := resolveHandler.lookupPolicyRecord(name, version)
+	switch {
+	case err != nil:
+		check.details = []string{err.Error()}
+	case record == "":
+		check.marker = warningMarker
+		check.details = []string{fmt.Sprintf("`%s` has no %s record.", name, version)}
+	default:
+		check.marker = successMarker
+		check.details = []string{fmt.Sprintf("`%s`", record)}
+	}
+	return check
+}
+
+// lookupPolicyRecord returns the TXT record of the given name which starts with the given version tag.
+func (resolveHandler *ResolveHandler) lookupPolicyRecord(name string, version string) (string, error) {
+	records, err := resolveHandler.lookupTXT(name)
+	if err != nil {
+		return "", err
+	}
+	for _, record := range records {
+		if strings.HasPrefix(strings.ToLower(strings.TrimSpace(record)), strings.ToLower(version)) {
+			return record, nil
+		}
+	}
+	return "", nil
+}
+
+// lookupTXT returns the TXT records of the given name. The strings of a single record are concatenated.
+func (resolveHandler *ResolveHandler) lookupTXT(name string) ([]string, error) {
+	records, err := resolveHandler.lookupRecords(name, dns.TypeTXT)
+	if err != nil {
+		return nil, err
+	}
+	var txtRecords []string
+	for _, record := range records {
+		if txt, isTXT := record.(*dns.TXT); isTXT {
+			txtRecords = append(txtRecords, strings.Join(txt.Txt, ""))
+		}
+	}
+	return txtRecords, nil
+}
+
+// lookupRecords queries the configured upstream resolver for the records of the given name and type. A non-existent
+// domain results in no records instead of an error.
+func (resolveHandler *ResolveHandler) lookupRecords(name string, messageType uint16) ([]dns.RR, error) {
+	message := &dns.Msg{}
+	message.SetQuestion(dns.Fqdn(name), messageType)
+	response, _, err := resolveHandler.exchange(message)
+	if err != nil {
+		return nil, err
+	}
+	if response.Rcode == dns.RcodeNameError {
+		return nil, nil
+	}
+	if errorMessage, ok := validateDNSResponseCode(response.Rcode); !ok {
+		return nil, fmt.Errorf("%s while looking up %s %s", errorMessage, dns.TypeToString[messageType], name)
+	}
+	return response.Answer, nil
+}
+
+// parseTagList parses a tag list (e.g. "v=DMARC1; p=reject") as used by DMARC and DKIM records.
+func parseTagList(record string) map[string]string {
+	tags := make(map[string]string)
+	for _, tag := range strings.Split(record, ";") {
+		nameAndValue := strings.SplitN(tag, "=", 2)
+		if len(nameAndValue) != 2 {
+			continue
+		}
+		tags[strings.ToLower(strings.TrimSpace(nameAndValue[0]))] = strings.Join(strings.Fields(nameAndValue[1]), "")
+	}
+	return tags
+}
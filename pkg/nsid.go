@@ -0,0 +1,66 @@
+package discord1111resolver
+
+import (
+	"encoding/hex"
+	"github.com/miekg/dns"
+	"strings"
+)
+
+const (
+	// nSIDFooterFormat is appended to the footer if the upstream identified itself with the EDNS0 NSID option.
+	nSIDFooterFormat = " Served by %s (NSID)."
+	// dNSClassSyntax describes the optional class of a query (e.g. CH TXT id.server).
+	dNSClassSyntax = "IN|CH|HS"
+)
+
+// dNSClasses contains the DNS classes which can be queried by Discord users indexed by their mnemonic. The CHAOS class
+// is used to identify the answering server (e.g. id.server or hostname.bind).
+var dNSClasses = map[string]uint16{
+	"IN":    dns.ClassINET,
+	"CH":    dns.ClassCHAOS,
+	"CHAOS": dns.ClassCHAOS,
+	"HS":    dns.ClassHESIOD,
+}
+
+// validateDNSClass checks whether the parameter is a DNS class mnemonic and returns the class.
+func validateDNSClass(classString string) (class uint16, ok bool) {
+	class, ok = dNSClasses[strings.ToUpper(classString)]
+	return
+}
+
+// requestNSID adds the EDNS0 NSID option to the message to make the upstream identify the server which answered
+// (RFC 5001). It reuses the OPT record if the message already has one.
+func requestNSID(message *dns.Msg) {
+	option := message.IsEdns0()
+	if option == nil {
+		message.SetEdns0(dns.DefaultMsgSize, false)
+		option = message.IsEdns0()
+	}
+	option.Option = append(option.Option, &dns.EDNS0_NSID{Code: dns.EDNS0NSID})
+}
+
+// responseNSID returns the printable server identifier of the response or an empty string if the upstream did not
+// return an NSID option. Identifiers which are not printable are returned hex encoded.
+func responseNSID(response *dns.Msg) string {
+	option := response.IsEdns0()
+	if option == nil {
+		return ""
+	}
+	for _, ednsOption := range option.Option {
+		nsid, isNSID := ednsOption.(*dns.EDNS0_NSID)
+		if !isNSID || nsid.Nsid == "" {
+			continue
+		}
+		identifier, err := hex.DecodeString(nsid.Nsid)
+		if err != nil {
+			return nsid.Nsid
+		}
+		for _, character := range identifier {
+			if character < ' ' || character > '~' {
+				return nsid.Nsid
+			}
+		}
+		return string(identifier)
+	}
+	return ""
+}
@@ -0,0 +1,136 @@
+package discord1111resolver
+
+import (
+	"fmt"
+	"github.com/miekg/dns"
+	"sort"
+	"strings"
+)
+
+const (
+	// queryOptionPrefix marks a parameter as a query flag (e.g. +dnssec).
+	queryOptionPrefix = "+"
+	// queryValueOptionPrefix marks a parameter as a query option which is followed by a value (e.g. --profile family).
+	queryValueOptionPrefix = "--"
+	// queryOptionValueSeparator separates an option from its value if both are passed as one parameter.
+	queryOptionValueSeparator = "="
+)
+
+// queryOptions contains all optional flags which modify how a DNS request is executed and rendered.
+type queryOptions struct {
+	// dnssec requests DNSSEC records and validates the chain of trust locally.
+	dnssec bool
+	// profile is the name of the resolver profile (e.g. security) which should answer the query.
+	profile string
+	// geo is a client subnet preset name or prefix which is sent as EDNS0 Client Subnet option.
+	geo string
+	// verbose renders the complete response (header flags, every section and TTLs) like dig does.
+	verbose bool
+	// wire attaches the response in presentation and wire format as files.
+	wire bool
+	// class is the DNS class of the question. It is not an option but part of the query grammar (e.g. CH TXT id.server)
+	// and defaults to IN.
+	class uint16
+}
+
+// queryOption describes a single option which can be passed along with a DNS query.
+type queryOption struct {
+	// syntax describes the option including its prefix and value (e.g. "--profile <name>").
+	syntax string
+	// takesValue is true if the option needs a value.
+	takesValue bool
+	// set applies the option (and its value) to the query options.
+	set func(options *queryOptions, value string) error
+}
+
+// supportedQueryOptions contains every supported query option indexed by its name.
+var supportedQueryOptions = map[string]*queryOption{
+	"dnssec": {
+		syntax: queryOptionPrefix + "dnssec",
+		set: func(options *queryOptions, value string) error {
+			options.dnssec = true
+			return nil
+		},
+	},
+	"verbose": {
+		syntax: queryOptionPrefix + "verbose",
+		set: func(options *queryOptions, value string) error {
+			options.verbose = true
+			return nil
+		},
+	},
+	"wire": {
+		syntax: queryOptionPrefix + "wire",
+		set: func(options *queryOptions, value string) error {
+			options.wire = true
+			return nil
+		},
+	},
+	"profile": {
+		syntax:     queryValueOptionPrefix + "profile <security|family>",
+		takesValue: true,
+		set: func(options *queryOptions, value string) error {
+			options.profile = strings.ToLower(value)
+			return nil
+		},
+	},
+	"geo": {
+		syntax:     queryValueOptionPrefix + "geo <preset|prefix>",
+		takesValue: true,
+		set: func(options *queryOptions, value string) error {
+			options.geo = value
+			return nil
+		},
+	},
+}
+
+// parseQueryOptions separates the query options from the remaining parameters. Options may either be prefixed with
+// "+" or "--" and their values may either be passed as the next parameter or separated by "=". If an option is unknown
+// or invalid, the offending parameter is returned and ok is false.
+func parseQueryOptions(params []string) (options *queryOptions, remainingParams []string, invalidOption string, ok bool) {
+	options = &queryOptions{class: dns.ClassINET}
+	remainingParams = make([]string, 0, len(params))
+	for index := 0; index < len(params); index++ {
+		param := params[index]
+		var name string
+		switch {
+		case strings.HasPrefix(param, queryValueOptionPrefix):
+			name = strings.TrimPrefix(param, queryValueOptionPrefix)
+		case strings.HasPrefix(param, queryOptionPrefix):
+			name = strings.TrimPrefix(param, queryOptionPrefix)
+		default:
+			remainingParams = append(remainingParams, param)
+			continue
+		}
+		var value string
+		hasValue := false
+		if separatorIndex := strings.Index(name, queryOptionValueSeparator); separatorIndex >= 0 {
+			name, value, hasValue = name[:separatorIndex], name[separatorIndex+1:], true
+		}
+		option, found := supportedQueryOptions[strings.ToLower(name)]
+		if !found {
+			return nil, nil, param, false
+		}
+		if option.takesValue && !hasValue {
+			if index+1 >= len(params) {
+				return nil, nil, param, false
+			}
+			index++
+			value = params[index]
+		}
+		if err := option.set(options, value); err != nil {
+			return nil, nil, fmt.Sprintf("%s (%s)", param, err.Error()), false
+		}
+	}
+	return options, remainingParams, "", true
+}
+
+// queryOptionNames returns the sorted syntax of all supported query options.
+func queryOptionNames() []string {
+	names := make([]string, 0, len(supportedQueryOptions))
+	for _, option := range supportedQueryOptions {
+		names = append(names, option.syntax)
+	}
+	sort.Strings(names)
+	return names
+}
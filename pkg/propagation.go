@@ -0,0 +1,403 @@
+package discord1111resolver
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/bwmarrin/discordgo"
+	"github.com/miekg/dns"
+	"github.com/sirupsen/logrus"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// propagationIntervalKeyword introduces the refresh interval of the propagation command (e.g. every 30s).
+	propagationIntervalKeyword = "every"
+	// minimumPropagationInterval is the minimum interval between two propagation checks of the same message.
+	minimumPropagationInterval = 10 * time.Second
+	// maximumPropagationDuration is the maximum time a propagation check is refreshed.
+	maximumPropagationDuration = 30 * time.Minute
+	// propagationTableRowFormat is used to render a single row of the propagation table.
+	propagationTableRowFormat = "%-28s %-7s %-7s %s\n"
+	// maximumPropagationAnswerLength is the maximum length of the answer column of the propagation table.
+	maximumPropagationAnswerLength = 40
+	// propagationCheckedFormat is used to describe when the servers were checked.
+	propagationCheckedFormat = "Checked at %s."
+	// maximumPropagationRefreshes is the maximum number of propagation checks which are refreshed at the same time.
+	maximumPropagationRefreshes = 20
+)
+
+// propagationTarget describes a server which is queried by the propagation command.
+type propagationTarget struct {
+	// name is the name of the authoritative name server or the address and operator of the public resolver.
+	name string
+	// address is the address (ip:port) the queries are sent to.
+	address string
+	// authoritative is true for the authoritative name servers of the zone.
+	authoritative bool
+}
+
+// propagationCheck contains everything which is needed to repeat a propagation check.
+type propagationCheck struct {
+	// recordType is the queried record type.
+	recordType *dNSRecordType
+	// domain is the queried fully qualified domain name.
+	domain string
+	// zone is the zone the domain is part of.
+	zone string
+	// expected is the normalized value the user expects (empty if the authoritative answer is expected).
+	expected string
+	// targets contains the authoritative name servers followed by the public resolvers.
+	targets []propagationTarget
+	// rounds is the number of times the servers were checked.
+	rounds int
+}
+
+// propagationRefreshes tracks the running refreshes of propagation checks to limit their number and to stop them on
+// shutdown.
+type propagationRefreshes struct {
+	sync.Mutex
+	// stops contains the channel which stops a refresh indexed by the ID of the refreshed message (lazily initialized).
+	stops map[string]chan struct{}
+	// stopped is true once StopPropagationRefreshes was called, no refreshes are started afterwards.
+	stopped bool
+}
+
+// propagationResult contains the answer of a single server.
+type propagationResult struct {
+	// values contains the sorted values of the records of the queried type.
+	values []string
+	// ttl is the lowest (remaining) TTL of the records of the queried type.
+	ttl uint32
+	// err contains the error which occurred while querying the server.
+	err error
+	// rcode is the response code of the server.
+	rcode int
+}
+
+func (resolveHandler *ResolveHandler) handlePropagationCommand(messageCreate *discordgo.MessageCreate,
+	messageSend *messageReply, options *queryOptions, params []string) (ok bool) {
+	messageEmbed := messageSend.Embed
+	var interval time.Duration
+	if len(params) >= 2 && strings.EqualFold(params[len(params)-2], propagationIntervalKeyword) {
+		var err error
+		if interval, err = time.ParseDuration(params[len(params)-1]); err != nil || interval < minimumPropagationInterval {
+			intervalString := params[len(params)-1]
+			trimDiscordFieldValue(&intervalString)
+			messageEmbed.Fields = []*discordgo.MessageEmbedField{{
+				Name:   fmt.Sprintf("Invalid interval (at least %v):", minimumPropagationInterval),
+				Value:  strconv.Quote(intervalString),
+				Inline: true,
+			}}
+			return false
+		}
+		params = params[:len(params)-2]
+	}
+	if len(params) < 2 {
+		return false
+	}
+	recordType, ok := validateDNSMessageTypeParam(messageEmbed, params[0])
+	if !ok {
+		return false
+	}
+	domain, ok := prepareDomainName(messageEmbed, params[1])
+	if !ok {
+		return false
+	}
+	check := &propagationCheck{recordType: recordType, domain: domain}
+	// the expected value may consist of several words (e.g. "10 mail.example.com." or a quoted TXT value)
+	if len(params) > 2 {
+		check.expected = normalizePropagationValue(strings.Join(params[2:], " "))
+	}
+	if err := resolveHandler.preparePropagationCheck(check); err != nil {
+		messageEmbed.Fields = []*discordgo.MessageEmbedField{{
+			Name:   "Could not find the authoritative name servers:",
+			Value:  strconv.Quote(err.Error()),
+			Inline: true,
+		}}
+		return false
+	}
+	converged := resolveHandler.runPropagationCheck(check, messageEmbed)
+	if converged || interval == 0 {
+		return converged
+	}
+	deadline := time.Now().Add(maximumPropagationDuration)
+	messageEmbed.Footer.Text += fmt.Sprintf(" Refreshing every %v until all servers are up to date (at most %v).",
+		interval, maximumPropagationDuration)
+	messageSend.followUp = func(session *discordgo.Session, message *discordgo.Message) {
+		stop, started := resolveHandler.propagationRefreshes.start(message.ID)
+		if !started {
+			messageEmbed.Footer.Text = fmt.Sprintf(propagationCheckedFormat, time.Now().UTC().Format("15:04:05 MST")) +
+				" Not refreshing because too many propagation checks are running."
+			resolveHandler.editPropagationMessage(session, message, messageEmbed)
+			return
+		}
+		defer resolveHandler.propagationRefreshes.finish(message.ID)
+		resolveHandler.refreshPropagationCheck(session, message, messageEmbed, check, interval, deadline, stop)
+	}
+	return false
+}
+
+// preparePropagationCheck finds the zone of the domain and the addresses of its authoritative name servers and adds
+// them and the public resolvers to the targets of the check.
+func (resolveHandler *ResolveHandler) preparePropagationCheck(check *propagationCheck) error {
+	message := &dns.Msg{}
+	message.SetQuestion(check.domain, dns.TypeSOA)
+	response, _, err := resolveHandler.exchange(message)
+	if err != nil {
+		return err
+	}
+	// the SOA record is either the answer (the domain is the apex) or part of the authority section
+	for _, record := range append(response.Answer, response.Ns...) {
+		if soa, isSOA := record.(*dns.SOA); isSOA {
+			check.zone = soa.Hdr.Name
+		}
+	}
+	if check.zone == "" {
+		return fmt.Errorf("could not find the zone of %s", check.domain)
+	}
+	nameServers, err := resolveHandler.lookupNameServers(check.zone)
+	if err != nil {
+		return err
+	}
+	for _, nameServer := range nameServers {
+		address, err := resolveHandler.lookupAddress(nameServer)
+		if err != nil {
+			continue
+		}
+		check.targets = append(check.targets, propagationTarget{
+			name:          nameServer,
+			address:       net.JoinHostPort(address, "53"),
+			authoritative: true,
+		})
+	}
+	if len(check.targets) == 0 {
+		return fmt.Errorf("could not resolve any name server of %s", check.zone)
+	}
+	for _, resolver := range resolveHandler.publicResolvers() {
+		check.targets = append(check.targets, propagationTarget{
+			name:    fmt.Sprintf("%s (%s)", resolver.address, resolver.operator),
+			address: resolverAddress(resolver.address),
+		})
+	}
+	return nil
+}
+
+// runPropagationCheck queries every target of the check in parallel and renders the results into the message embed.
+// It returns whether every server returns the expected value.
+func (resolveHandler *ResolveHandler) runPropagationCheck(check *propagationCheck, messageEmbed *discordgo.MessageEmbed) (converged bool) {
+	check.rounds++
+	results := make([]*propagationResult, len(check.targets))
+	waitGroup := &sync.WaitGroup{}
+	for index, target := range check.targets {
+		waitGroup.Add(1)
+		go func(index int, target propagationTarget) {
+			defer waitGroup.Done()
+			results[index] = resolveHandler.queryPropagationTarget(check, target)
+		}(index, target)
+	}
+	waitGroup.Wait()
+	expected := check.expected
+	expectedDescription := fmt.Sprintf("`%s`", expected)
+	trimDiscordFieldValue(&expectedDescription)
+	if expected == "" {
+		expected = authoritativePropagationValue(check, results)
+		expectedDescription = "the authoritative answer"
+		// without an authoritative answer no server can be up to date
+		if expected == "" {
+			expectedDescription = "cannot be determined, no authoritative name server returned records"
+		}
+	}
+	table := &bytes.Buffer{}
+	fmt.Fprintf(table, propagationTableRowFormat, "server", "status", "ttl", "answer")
+	upToDate := 0
+	for index, result := range results {
+		status, ttl, answer := "old", "-", strings.Join(result.values, ", ")
+		switch {
+		case result.err != nil:
+			status, answer = "error", result.err.Error()
+		case result.rcode != dns.RcodeSuccess:
+			errorMessage, _ := validateDNSResponseCode(result.rcode)
+			status, answer = "failed", errorMessage
+		case len(result.values) == 0:
+			answer = "no records"
+		default:
+			ttl = strconv.FormatUint(uint64(result.ttl), 10) + "s"
+		}
+		if result.err == nil && result.rcode == dns.RcodeSuccess && expected != "" &&
+			containsPropagationValue(result.values, expected, check.expected == "") {
+			status = "ok"
+			upToDate++
+		}
+		if len(answer) > maximumPropagationAnswerLength {
+			answer = answer[:maximumPropagationAnswerLength-3] + "..."
+		}
+		fmt.Fprintf(table, propagationTableRowFormat, check.targets[index].name, status, ttl, answer)
+	}
+	converged = upToDate == len(results)
+	messageEmbed.Description = fmt.Sprintf("Propagation of `%s %s` to the authoritative name servers of `%s` and "+
+		"public resolvers.\n```\n%s```", check.recordType.name, check.domain, check.zone, table.String())
+	messageEmbed.Fields = []*discordgo.MessageEmbedField{{
+		Name:   "Expected value:",
+		Value:  expectedDescription,
+		Inline: true,
+	}, {
+		Name:   "Up to date:",
+		Value:  fmt.Sprintf("%d of %d servers", upToDate, len(results)),
+		Inline: true,
+	}}
+	messageEmbed.Footer = &discordgo.MessageEmbedFooter{
+		Text: fmt.Sprintf(propagationCheckedFormat, time.Now().UTC().Format("15:04:05 MST")),
+	}
+	if converged {
+		messageEmbed.Color = embedSuccessColor
+	} else {
+		messageEmbed.Color = embedErrorColor
+	}
+	return converged
+}
+
+// refreshPropagationCheck repeats the propagation check and edits the message until every server is up to date, the
+// deadline is reached or the stop channel is closed.
+func (resolveHandler *ResolveHandler) refreshPropagationCheck(session *discordgo.Session, message *discordgo.Message,
+	messageEmbed *discordgo.MessageEmbed, check *propagationCheck, interval time.Duration, deadline time.Time,
+	stop chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		converged := resolveHandler.runPropagationCheck(check, messageEmbed)
+		timedOut := time.Now().After(deadline)
+		switch {
+		case converged:
+			messageEmbed.Footer.Text += fmt.Sprintf(" All servers are up to date after %d checks.", check.rounds)
+		case timedOut:
+			messageEmbed.Footer.Text += fmt.Sprintf(" Stopped refreshing after %v.", maximumPropagationDuration)
+		default:
+			messageEmbed.Footer.Text += fmt.Sprintf(" Refreshing every %v (check %d).", interval, check.rounds)
+		}
+		if !resolveHandler.editPropagationMessage(session, message, messageEmbed) || converged || timedOut {
+			return
+		}
+	}
+}
+
+// editPropagationMessage replaces the message embed of the propagation check message. The embed limits are enforced
+// like for new messages, attachments cannot be added to an edited message though.
+func (resolveHandler *ResolveHandler) editPropagationMessage(session *discordgo.Session, message *discordgo.Message,
+	messageEmbed *discordgo.MessageEmbed) bool {
+	enforceEmbedLimits(&discordgo.MessageSend{Embed: messageEmbed})
+	if _, err := session.ChannelMessageEditEmbed(message.ChannelID, message.ID, messageEmbed); err != nil {
+		logrus.WithError(err).WithField("channel-id", message.ChannelID).Warn("could not edit discord message")
+		return false
+	}
+	return true
+}
+
+// start registers a refresh of the given message and returns the channel which stops it. No refresh is started if
+// maximumPropagationRefreshes are already running or the refreshes were stopped.
+func (refreshes *propagationRefreshes) start(messageID string) (stop chan struct{}, started bool) {
+	refreshes.Lock()
+	defer refreshes.Unlock()
+	if refreshes.stops == nil {
+		refreshes.stops = make(map[string]chan struct{})
+	}
+	if refreshes.stopped || len(refreshes.stops) >= maximumPropagationRefreshes {
+		return nil, false
+	}
+	stop = make(chan struct{})
+	refreshes.stops[messageID] = stop
+	return stop, true
+}
+
+// finish removes the finished refresh of the given message.
+func (refreshes *propagationRefreshes) finish(messageID string) {
+	refreshes.Lock()
+	defer refreshes.Unlock()
+	delete(refreshes.stops, messageID)
+}
+
+// StopPropagationRefreshes stops refreshing every propagation check message.
+func (resolveHandler *ResolveHandler) StopPropagationRefreshes() {
+	refreshes := &resolveHandler.propagationRefreshes
+	refreshes.Lock()
+	defer refreshes.Unlock()
+	refreshes.stopped = true
+	for _, stop := range refreshes.stops {
+		close(stop)
+	}
+	refreshes.stops = nil
+}
+
+// queryPropagationTarget sends the question of the check to a single server. The authoritative name servers are asked
+// without recursion.
+func (resolveHandler *ResolveHandler) queryPropagationTarget(check *propagationCheck, target propagationTarget) *propagationResult {
+	message := &dns.Msg{}
+	message.SetQuestion(check.domain, check.recordType.messageType)
+	message.RecursionDesired = !target.authoritative
+	response, _, err := resolveHandler.exchangeDirect(message, target.address)
+	if err != nil {
+		return &propagationResult{err: err}
+	}
+	result := &propagationResult{rcode: response.Rcode}
+	for _, answer := range response.Answer {
+		if answer.Header().Rrtype != check.recordType.messageType {
+			continue
+		}
+		result.values = append(result.values, normalizePropagationValue(strings.TrimPrefix(answer.String(), answer.Header().String())))
+		if len(result.values) == 1 || answer.Header().Ttl < result.ttl {
+			result.ttl = answer.Header().Ttl
+		}
+	}
+	sort.Strings(result.values)
+	return result
+}
+
+// authoritativePropagationValue returns the answer most authoritative name servers agree on or an empty string if none of
+// them returned records.
+func authoritativePropagationValue(check *propagationCheck, results []*propagationResult) string {
+	votes := make(map[string]int)
+	var value string
+	for index, result := range results {
+		if !check.targets[index].authoritative || result.err != nil || result.rcode != dns.RcodeSuccess ||
+			len(result.values) == 0 {
+			continue
+		}
+		key := strings.Join(result.values, ", ")
+		votes[key]++
+		if votes[key] > votes[value] {
+			value = key
+		}
+	}
+	return value
+}
+
+// containsPropagationValue checks whether the values contain the expected value. The expected value may also be the
+// complete (joined) answer, which has to match exactly if it is the authoritative answer.
+func containsPropagationValue(values []string, expected string, exactly bool) bool {
+	if strings.Join(values, ", ") == expected {
+		return true
+	}
+	if exactly {
+		return false
+	}
+	for _, value := range values {
+		if value == expected {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizePropagationValue normalizes a record value so that user input and record data can be compared.
+func normalizePropagationValue(value string) string {
+	return strings.TrimSuffix(strings.Trim(strings.ToLower(strings.TrimSpace(value)), "\""), ".")
+}
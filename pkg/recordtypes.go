@@ -0,0 +1,218 @@
+package discord1111resolver
+
+import (
+	"fmt"
+	"github.com/miekg/dns"
+	"strconv"
+	"strings"
+)
+
+const (
+	// dNSAnswerCodeFormat is used to render a single value of a DNS answer.
+	dNSAnswerCodeFormat = "`%s`"
+	// dNSAnswerFieldFormat is used to render a single named field of a DNS answer.
+	dNSAnswerFieldFormat = "%s `%s`"
+	// dNSAnswerFieldSeparator separates multiple named fields of a DNS answer.
+	dNSAnswerFieldSeparator = " · "
+)
+
+// dNSRecordType describes a DNS record type which can be queried by Discord users.
+type dNSRecordType struct {
+	// name is the presentation name of the record type (e.g. MX).
+	name string
+	// messageType is the DNS query message type code.
+	messageType uint16
+	// format renders every field of a resource record of this type.
+	format func(answer dns.RR) string
+	// help is a short description of the record type which is shown to Discord users.
+	help string
+}
+
+// dNSRecordTypes contains all supported DNS record types in the order they are presented to Discord users.
+var dNSRecordTypes = []*dNSRecordType{
+	{name: "A", messageType: dns.TypeA, format: formatA, help: "IPv4 address of a host"},
+	{name: "AAAA", messageType: dns.TypeAAAA, format: formatAAAA, help: "IPv6 address of a host"},
+	{name: "CNAME", messageType: dns.TypeCNAME, format: formatCNAME, help: "canonical name (alias target)"},
+	{name: "MX", messageType: dns.TypeMX, format: formatMX, help: "mail exchangers with their preference"},
+	{name: "TXT", messageType: dns.TypeTXT, format: formatTXT, help: "arbitrary text (SPF, verification tokens, ...)"},
+	{name: "NS", messageType: dns.TypeNS, format: formatNS, help: "authoritative name servers of a zone"},
+	{name: "SOA", messageType: dns.TypeSOA, format: formatSOA, help: "start of authority (serial and timers) of a zone"},
+	{name: "SRV", messageType: dns.TypeSRV, format: formatSRV, help: "service location (e.g. _sip._tcp.example.com)"},
+	{name: "CAA", messageType: dns.TypeCAA, format: formatCAA, help: "certificate authorities allowed to issue certificates"},
+	{name: "PTR", messageType: dns.TypePTR, format: formatPTR, help: "reverse pointer of an address"},
+	{name: "DS", messageType: dns.TypeDS, format: formatDS, help: "delegation signer of a DNSSEC signed zone"},
+	{name: "DNSKEY", messageType: dns.TypeDNSKEY, format: formatDNSKEY, help: "DNSSEC public keys of a zone"},
+	{name: "TLSA", messageType: dns.TypeTLSA, format: formatTLSA, help: "DANE certificate association (e.g. _443._tcp.example.com)"},
+	{name: "NAPTR", messageType: dns.TypeNAPTR, format: formatNAPTR, help: "naming authority pointer (rewrite rules)"},
+	{name: "SSHFP", messageType: dns.TypeSSHFP, format: formatSSHFP, help: "SSH host key fingerprints"},
+}
+
+// allowedDNSMessageTypes contains all allowed DNS query message types (e.g. A or AAAA) indexed by their name.
+var allowedDNSMessageTypes = make(map[string]*dNSRecordType, len(dNSRecordTypes))
+
+// dNSRecordTypesByCode contains all supported DNS record types indexed by their message type code.
+var dNSRecordTypesByCode = make(map[uint16]*dNSRecordType, len(dNSRecordTypes))
+
+func init() {
+	for _, recordType := range dNSRecordTypes {
+		allowedDNSMessageTypes[recordType.name] = recordType
+		dNSRecordTypesByCode[recordType.messageType] = recordType
+	}
+}
+
+// dNSRecordTypeNames returns the names of all supported record types in their presentation order.
+func dNSRecordTypeNames() []string {
+	names := make([]string, len(dNSRecordTypes))
+	for index, recordType := range dNSRecordTypes {
+		names[index] = recordType.name
+	}
+	return names
+}
+
+// dNSRecordTypesHelp returns a short help line for every supported record type and its description.
+func dNSRecordTypesHelp() []string {
+	lines := make([]string, len(dNSRecordTypes))
+	for index, recordType := range dNSRecordTypes {
+		lines[index] = fmt.Sprintf("**%s** - %s", recordType.name, recordType.help)
+	}
+	return lines
+}
+
+// formatDNSAnswerFields renders alternating field names and values (e.g. "priority", "10") of a DNS answer.
+func formatDNSAnswerFields(namesAndValues ...string) string {
+	fields := make([]string, 0, len(namesAndValues)/2)
+	for index := 0; index+1 < len(namesAndValues); index += 2 {
+		fields = append(fields, fmt.Sprintf(dNSAnswerFieldFormat, namesAndValues[index], namesAndValues[index+1]))
+	}
+	return strings.Join(fields, dNSAnswerFieldSeparator)
+}
+
+func formatA(answer dns.RR) string {
+	return fmt.Sprintf(dNSAnswerCodeFormat, answer.(*dns.A).A.String())
+}
+
+func formatAAAA(answer dns.RR) string {
+	return fmt.Sprintf(dNSAnswerCodeFormat, answer.(*dns.AAAA).AAAA.String())
+}
+
+func formatCNAME(answer dns.RR) string {
+	return fmt.Sprintf(dNSAnswerCodeFormat, answer.(*dns.CNAME).Target)
+}
+
+func formatMX(answer dns.RR) string {
+	mx := answer.(*dns.MX)
+	return formatDNSAnswerFields("preference", strconv.Itoa(int(mx.Preference)), "exchange", mx.Mx)
+}
+
+func formatTXT(answer dns.RR) string {
+	txt := answer.(*dns.TXT)
+	quoted := make([]string, len(txt.Txt))
+	for index, value := range txt.Txt {
+		quoted[index] = strconv.Quote(value)
+	}
+	return fmt.Sprintf(dNSAnswerCodeFormat, strings.Join(quoted, " "))
+}
+
+func formatNS(answer dns.RR) string {
+	return fmt.Sprintf(dNSAnswerCodeFormat, answer.(*dns.NS).Ns)
+}
+
+func formatSOA(answer dns.RR) string {
+	soa := answer.(*dns.SOA)
+	return formatDNSAnswerFields(
+		"mname", soa.Ns,
+		"rname", soa.Mbox,
+		"serial", strconv.FormatUint(uint64(soa.Serial), 10),
+		"refresh", strconv.FormatUint(uint64(soa.Refresh), 10),
+		"retry", strconv.FormatUint(uint64(soa.Retry), 10),
+		"expire", strconv.FormatUint(uint64(soa.Expire), 10),
+		"minimum", strconv.FormatUint(uint64(soa.Minttl), 10),
+	)
+}
+
+func formatSRV(answer dns.RR) string {
+	srv := answer.(*dns.SRV)
+	return formatDNSAnswerFields(
+		"priority", strconv.Itoa(int(srv.Priority)),
+		"weight", strconv.Itoa(int(srv.Weight)),
+		"port", strconv.Itoa(int(srv.Port)),
+		"target", srv.Target,
+	)
+}
+
+func formatCAA(answer dns.RR) string {
+	caa := answer.(*dns.CAA)
+	return formatDNSAnswerFields("flags", strconv.Itoa(int(caa.Flag)), "tag", caa.Tag, "value", caa.Value)
+}
+
+func formatPTR(answer dns.RR) string {
+	return fmt.Sprintf(dNSAnswerCodeFormat, answer.(*dns.PTR).Ptr)
+}
+
+func formatDS(answer dns.RR) string {
+	ds := answer.(*dns.DS)
+	return formatDNSAnswerFields(
+		"key tag", strconv.Itoa(int(ds.KeyTag)),
+		"algorithm", formatDNSSECAlgorithm(ds.Algorithm),
+		"digest type", formatDNSSECDigestType(ds.DigestType),
+		"digest", ds.Digest,
+	)
+}
+
+func formatDNSKEY(answer dns.RR) string {
+	dnskey := answer.(*dns.DNSKEY)
+	return formatDNSAnswerFields(
+		"flags", strconv.Itoa(int(dnskey.Flags)),
+		"protocol", strconv.Itoa(int(dnskey.Protocol)),
+		"algorithm", formatDNSSECAlgorithm(dnskey.Algorithm),
+		"key tag", strconv.Itoa(int(dnskey.KeyTag())),
+		"public key", dnskey.PublicKey,
+	)
+}
+
+func formatTLSA(answer dns.RR) string {
+	tlsa := answer.(*dns.TLSA)
+	return formatDNSAnswerFields(
+		"usage", strconv.Itoa(int(tlsa.Usage)),
+		"selector", strconv.Itoa(int(tlsa.Selector)),
+		"matching type", strconv.Itoa(int(tlsa.MatchingType)),
+		"certificate data", tlsa.Certificate,
+	)
+}
+
+func formatNAPTR(answer dns.RR) string {
+	naptr := answer.(*dns.NAPTR)
+	return formatDNSAnswerFields(
+		"order", strconv.Itoa(int(naptr.Order)),
+		"preference", strconv.Itoa(int(naptr.Preference)),
+		"flags", strconv.Quote(naptr.Flags),
+		"service", strconv.Quote(naptr.Service),
+		"regexp", strconv.Quote(naptr.Regexp),
+		"replacement", naptr.Replacement,
+	)
+}
+
+func formatSSHFP(answer dns.RR) string {
+	sshfp := answer.(*dns.SSHFP)
+	return formatDNSAnswerFields(
+		"algorithm", strconv.Itoa(int(sshfp.Algorithm)),
+		"type", strconv.Itoa(int(sshfp.Type)),
+		"fingerprint", sshfp.FingerPrint,
+	)
+}
+
+// formatDNSSECAlgorithm renders a DNSSEC algorithm number together with its mnemonic if it is known.
+func formatDNSSECAlgorithm(algorithm uint8) string {
+	if name, ok := dns.AlgorithmToString[algorithm]; ok {
+		return fmt.Sprintf("%d (%s)", algorithm, name)
+	}
+	return strconv.Itoa(int(algorithm))
+}
+
+// formatDNSSECDigestType renders a DNSSEC digest type number together with its mnemonic if it is known.
+func formatDNSSECDigestType(digestType uint8) string {
+	if name, ok := dns.HashToString[digestType]; ok {
+		return fmt.Sprintf("%d (%s)", digestType, name)
+	}
+	return strconv.Itoa(int(digestType))
+}
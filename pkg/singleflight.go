@@ -0,0 +1,70 @@
+package discord1111resolver
+
+import (
+	"github.com/miekg/dns"
+	"sync"
+	"time"
+)
+
+// inflightQuery is a query which is currently sent to the upstream resolver.
+type inflightQuery struct {
+	// waitGroup is done as soon as the response was received.
+	waitGroup sync.WaitGroup
+	// response is the response of the upstream resolver.
+	response *dns.Msg
+	// duration is the round trip time of the upstream query.
+	duration time.Duration
+	// err is the error which occurred while sending the query.
+	err error
+}
+
+// queryGroup collapses identical queries which are in flight at the same time, so that a single upstream exchange
+// serves every waiting caller (modelled after the singleflight implementation of the miekg/dns package).
+type queryGroup struct {
+	sync.Mutex
+	// queries contains the queries in flight indexed by their cache key (lazily initialized).
+	queries map[string]*inflightQuery
+	// collapsed is the number of queries which were answered by the exchange of another query.
+	collapsed uint64
+}
+
+// do executes the exchange unless an identical query is already in flight, in which case it waits for and returns a
+// copy of that response. shared is true if the response was returned by the exchange of another query.
+func (group *queryGroup) do(key string, exchange func() (*dns.Msg, time.Duration, error)) (response *dns.Msg, duration time.Duration, shared bool, err error) {
+	group.Lock()
+	if group.queries == nil {
+		group.queries = make(map[string]*inflightQuery)
+	}
+	if query, found := group.queries[key]; found {
+		group.collapsed++
+		group.Unlock()
+		query.waitGroup.Wait()
+		if query.err != nil {
+			return nil, 0, true, query.err
+		}
+		// every caller gets its own copy because the responses are modified while they are rendered
+		return query.response.Copy(), query.duration, true, nil
+	}
+	query := &inflightQuery{}
+	query.waitGroup.Add(1)
+	group.queries[key] = query
+	group.Unlock()
+	response, duration, err = exchange()
+	if err == nil {
+		query.response = response.Copy()
+	}
+	query.duration, query.err = duration, err
+	query.waitGroup.Done()
+	group.Lock()
+	delete(group.queries, key)
+	group.Unlock()
+	return response, duration, false, err
+}
+
+// CollapsedQueries returns the number of queries which were not sent to the upstream resolver because an identical
+// query was already in flight.
+func (resolveHandler *ResolveHandler) CollapsedQueries() uint64 {
+	resolveHandler.inflight.Lock()
+	defer resolveHandler.inflight.Unlock()
+	return resolveHandler.inflight.collapsed
+}
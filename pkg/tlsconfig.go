@@ -0,0 +1,84 @@
+package discord1111resolver
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// SPKIPinMismatchError is returned by the TLS handshake if none of the certificates presented by the upstream
+// resolver matches one of the configured SPKI pins (RFC 7858 out-of-band key-pinned privacy profile).
+type SPKIPinMismatchError struct {
+	// ServerName is the server name which was verified.
+	ServerName string
+	// Pins contains the base64 encoded SPKI SHA-256 fingerprints of all presented certificates.
+	Pins []string
+}
+
+func (err *SPKIPinMismatchError) Error() string {
+	return fmt.Sprintf("none of the presented certificates of %q matches the configured SPKI pins (got %s)",
+		err.ServerName, strings.Join(err.Pins, ", "))
+}
+
+// newTLSConfig creates the TLS configuration of a DNS over TLS upstream. It verifies the certificate against the
+// configured CA bundle (or the system pool) and, if pins are configured, checks the SPKI fingerprints of the chain.
+func newTLSConfig(config UpstreamConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{ServerName: config.TLSServerName}
+	if config.TLSCAFile != "" {
+		pemBytes, err := ioutil.ReadFile(config.TLSCAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pemBytes) {
+			return nil, fmt.Errorf("could not find any certificate in the CA bundle %q", config.TLSCAFile)
+		}
+	}
+	if len(config.SPKIPins) == 0 {
+		return tlsConfig, nil
+	}
+	pins := make([][]byte, len(config.SPKIPins))
+	for index, pin := range config.SPKIPins {
+		decodedPin, err := base64.StdEncoding.DecodeString(pin)
+		if err != nil {
+			return nil, fmt.Errorf("could not decode SPKI pin %q: %s", pin, err.Error())
+		}
+		if len(decodedPin) != sha256.Size {
+			return nil, fmt.Errorf("SPKI pin %q is not a SHA-256 fingerprint", pin)
+		}
+		pins[index] = decodedPin
+	}
+	serverName := tlsConfig.ServerName
+	tlsConfig.VerifyPeerCertificate = func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+		return verifySPKIPins(serverName, pins, rawCerts)
+	}
+	return tlsConfig, nil
+}
+
+// verifySPKIPins checks whether any of the presented certificates matches one of the pins.
+func verifySPKIPins(serverName string, pins [][]byte, rawCerts [][]byte) error {
+	if len(rawCerts) == 0 {
+		return errors.New("the upstream resolver did not present any certificate")
+	}
+	presentedPins := make([]string, 0, len(rawCerts))
+	for _, rawCert := range rawCerts {
+		certificate, err := x509.ParseCertificate(rawCert)
+		if err != nil {
+			return err
+		}
+		fingerprint := sha256.Sum256(certificate.RawSubjectPublicKeyInfo)
+		for _, pin := range pins {
+			if bytes.Equal(fingerprint[:], pin) {
+				return nil
+			}
+		}
+		presentedPins = append(presentedPins, base64.StdEncoding.EncodeToString(fingerprint[:]))
+	}
+	return &SPKIPinMismatchError{ServerName: serverName, Pins: presentedPins}
+}
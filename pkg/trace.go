@@ -0,0 +1,224 @@
+package discord1111resolver
+
+import (
+	"errors"
+	"fmt"
+	"github.com/bwmarrin/discordgo"
+	"github.com/miekg/dns"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// maximumTraceHops is the maximum number of delegations which are followed by the trace command.
+	maximumTraceHops = 16
+	// maximumTraceAttempts is the maximum number of name servers which are asked for a single delegation step.
+	maximumTraceAttempts = 3
+	// maximumReferralServers is the maximum number of name server names which are shown for a single referral.
+	maximumReferralServers = 4
+	// traceDurationFormat is used to summarize a trace in the embed footer.
+	traceDurationFormat = "Traced %d delegation steps in %v."
+)
+
+// nameServer describes a DNS server by its name and its (optional) IP address.
+type nameServer struct {
+	name    string
+	address string
+}
+
+// rootHints contains the names and IPv4 addresses of the root name servers.
+var rootHints = []nameServer{
+	{name: "a.root-servers.net.", address: "198.41.0.4"},
+	{name: "b.root-servers.net.", address: "170.247.170.2"},
+	{name: "c.root-servers.net.", address: "192.33.4.12"},
+	{name: "d.root-servers.net.", address: "199.7.91.13"},
+	{name: "e.root-servers.net.", address: "192.203.230.10"},
+	{name: "f.root-servers.net.", address: "192.5.5.241"},
+	{name: "g.root-servers.net.", address: "192.112.36.4"},
+	{name: "h.root-servers.net.", address: "198.97.190.53"},
+	{name: "i.root-servers.net.", address: "192.36.148.17"},
+	{name: "j.root-servers.net.", address: "192.58.128.30"},
+	{name: "k.root-servers.net.", address: "193.0.14.129"},
+	{name: "l.root-servers.net.", address: "199.7.83.42"},
+	{name: "m.root-servers.net.", address: "202.12.27.33"},
+}
+
+// traceHop describes a single delegation step of an iterative resolution.
+type traceHop struct {
+	// zone is the zone the queried name server is authoritative for.
+	zone string
+	// server is the name server which answered (or failed to answer) the query.
+	server nameServer
+	// duration is the time the name server needed to answer.
+	duration time.Duration
+	// response is the response of the name server.
+	response *dns.Msg
+	// err contains the last error if no name server of the zone could be reached.
+	err error
+	// referralZone is the child zone the name server referred to (empty if this is the last step).
+	referralZone string
+	// referralServers contains the name servers of the child zone.
+	referralServers []nameServer
+}
+
+func (resolveHandler *ResolveHandler) handleTraceCommand(messageCreate *discordgo.MessageCreate,
+	messageSend *messageReply, options *queryOptions, params []string) (ok bool) {
+	messageEmbed := messageSend.Embed
+	recordType := allowedDNSMessageTypes["A"]
+	switch len(params) {
+	case 1:
+	case 2:
+		if recordType, ok = validateDNSMessageTypeParam(messageEmbed, params[0]); !ok {
+			return false
+		}
+		params = params[1:]
+	default:
+		return false
+	}
+	domain, ok := prepareDomainName(messageEmbed, params[0])
+	if !ok {
+		return false
+	}
+	start := time.Now()
+	hops := resolveHandler.trace(domain, recordType.messageType)
+	messageEmbed.Description = fmt.Sprintf("Iterative resolution of `%s %s` starting at the root name servers.",
+		recordType.name, domain)
+	messageEmbed.Fields = make([]*discordgo.MessageEmbedField, len(hops))
+	for index, hop := range hops {
+		value := formatTraceHop(hop)
+		trimDiscordFieldValue(&value)
+		messageEmbed.Fields[index] = &discordgo.MessageEmbedField{
+			Name:  fmt.Sprintf("%d. %s", index+1, hop.zone),
+			Value: value,
+		}
+	}
+	messageEmbed.Footer = &discordgo.MessageEmbedFooter{Text: fmt.Sprintf(traceDurationFormat, len(hops), time.Since(start))}
+	lastHop := hops[len(hops)-1]
+	return lastHop.err == nil && lastHop.referralZone == "" && lastHop.response.Rcode == dns.RcodeSuccess &&
+		len(lastHop.response.Answer) > 0
+}
+
+// trace follows the delegations from the root name servers down to the zone which is authoritative for the given
+// domain and returns every step.
+func (resolveHandler *ResolveHandler) trace(domain string, messageType uint16) []*traceHop {
+	var hops []*traceHop
+	zone, servers := ".", rootHints
+	for len(hops) < maximumTraceHops {
+		hop := resolveHandler.queryZone(zone, servers, domain, messageType)
+		hops = append(hops, hop)
+		if hop.err != nil || hop.referralZone == "" {
+			break
+		}
+		zone, servers = hop.referralZone, hop.referralServers
+	}
+	return hops
+}
+
+// queryZone asks the name servers of a zone (in random order) until one of them answers.
+func (resolveHandler *ResolveHandler) queryZone(zone string, servers []nameServer, domain string, messageType uint16) *traceHop {
+	hop := &traceHop{zone: zone, err: errors.New("no name server address known")}
+	message := &dns.Msg{}
+	message.SetQuestion(domain, messageType)
+	message.RecursionDesired = false
+	attempts := 0
+	for _, index := range rand.Perm(len(servers)) {
+		if attempts >= maximumTraceAttempts {
+			break
+		}
+		server := servers[index]
+		if server.address == "" {
+			address, err := resolveHandler.lookupAddress(server.name)
+			if err != nil {
+				hop.server, hop.err = server, err
+				continue
+			}
+			server.address = address
+		}
+		attempts++
+		hop.server = server
+		hop.response, hop.duration, hop.err = resolveHandler.exchangeDirect(message, net.JoinHostPort(server.address, "53"))
+		if hop.err == nil {
+			hop.referralZone, hop.referralServers = parseReferral(hop.response, zone, domain)
+			return hop
+		}
+	}
+	return hop
+}
+
+// lookupAddress resolves the IPv4 address of a name server which was referred to without glue records.
+func (resolveHandler *ResolveHandler) lookupAddress(name string) (string, error) {
+	message := &dns.Msg{}
+	message.SetQuestion(dns.Fqdn(name), dns.TypeA)
+	response, _, err := resolveHandler.exchange(message)
+	if err != nil {
+		return "", err
+	}
+	for _, answer := range response.Answer {
+		if a, ok := answer.(*dns.A); ok {
+			return a.A.String(), nil
+		}
+	}
+	return "", fmt.Errorf("could not resolve the address of %s", name)
+}
+
+// parseReferral extracts the child zone and its name servers (including glue addresses) from a referral response. If
+// the response is not a referral, the returned zone is empty.
+func parseReferral(response *dns.Msg, zone string, domain string) (referralZone string, servers []nameServer) {
+	if response.Rcode != dns.RcodeSuccess || response.Authoritative || len(response.Answer) > 0 {
+		return "", nil
+	}
+	glue := make(map[string]string)
+	for _, extra := range response.Extra {
+		if a, ok := extra.(*dns.A); ok {
+			glue[strings.ToLower(a.Hdr.Name)] = a.A.String()
+		}
+	}
+	for _, authority := range response.Ns {
+		ns, ok := authority.(*dns.NS)
+		// only accept delegations to a child zone on the way to the domain
+		if !ok || !dns.IsSubDomain(ns.Hdr.Name, domain) || dns.CountLabel(ns.Hdr.Name) <= dns.CountLabel(zone) {
+			continue
+		}
+		referralZone = ns.Hdr.Name
+		servers = append(servers, nameServer{name: ns.Ns, address: glue[strings.ToLower(ns.Ns)]})
+	}
+	return
+}
+
+// formatTraceHop renders a single delegation step.
+func formatTraceHop(hop *traceHop) string {
+	if hop.err != nil {
+		if hop.server.name == "" {
+			return fmt.Sprintf("no name server could be queried: %s", hop.err.Error())
+		}
+		return fmt.Sprintf("`%s` (%s) failed: %s", hop.server.name, hop.server.address, hop.err.Error())
+	}
+	lines := []string{fmt.Sprintf("`%s` (%s) answered in %v", hop.server.name, hop.server.address, hop.duration)}
+	switch {
+	case hop.referralZone != "":
+		names := make([]string, 0, maximumReferralServers)
+		for index, server := range hop.referralServers {
+			if index == maximumReferralServers {
+				names = append(names, "...")
+				break
+			}
+			names = append(names, server.name)
+		}
+		lines = append(lines, fmt.Sprintf("referral to `%s` (%d NS: %s)", hop.referralZone, len(hop.referralServers),
+			strings.Join(names, ", ")))
+	case hop.response.Rcode != dns.RcodeSuccess:
+		errorMessage, _ := validateDNSResponseCode(hop.response.Rcode)
+		lines = append(lines, errorMessage)
+	case len(hop.response.Answer) == 0:
+		lines = append(lines, "no records of the requested type (authoritative: "+
+			strconv.FormatBool(hop.response.Authoritative)+")")
+	default:
+		for _, answer := range hop.response.Answer {
+			lines = append(lines, parseDNSAnswer(answer))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
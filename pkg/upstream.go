@@ -0,0 +1,268 @@
+package discord1111resolver
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"github.com/miekg/dns"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// TransportTLS sends DNS messages over TLS (RFC 7858).
+	TransportTLS = "dot"
+	// TransportUDP sends plain DNS messages over UDP and retries truncated responses over TCP.
+	TransportUDP = "udp"
+	// TransportTCP sends plain DNS messages over TCP.
+	TransportTCP = "tcp"
+	// TransportHTTPS sends DNS messages over HTTPS (RFC 8484).
+	TransportHTTPS = "doh"
+	// dNSMessageContentType is the media type of DNS wire format messages sent over HTTPS.
+	dNSMessageContentType = "application/dns-message"
+	// defaultUpstreamTimeout is the timeout used if the upstream configuration does not specify one.
+	defaultUpstreamTimeout = 5 * time.Second
+)
+
+// defaultUpstreamAddresses contains the 1.1.1.1 DNS service addresses for every transport.
+var defaultUpstreamAddresses = map[string]string{
+	TransportTLS:   "1.1.1.1:853",
+	TransportUDP:   "1.1.1.1:53",
+	TransportTCP:   "1.1.1.1:53",
+	TransportHTTPS: "https://cloudflare-dns.com/dns-query",
+}
+
+const (
+	// ProfileSecurity is the name of the 1.1.1.2 resolver profile which blocks malware.
+	ProfileSecurity = "security"
+	// ProfileFamily is the name of the 1.1.1.3 resolver profile which blocks malware and adult content.
+	ProfileFamily = "family"
+)
+
+// profileUpstreamAddresses contains the addresses of the filtering 1.1.1.1 resolvers for every profile and transport.
+var profileUpstreamAddresses = map[string]map[string]string{
+	ProfileSecurity: {
+		TransportTLS:   "1.1.1.2:853",
+		TransportUDP:   "1.1.1.2:53",
+		TransportTCP:   "1.1.1.2:53",
+		TransportHTTPS: "https://security.cloudflare-dns.com/dns-query",
+	},
+	ProfileFamily: {
+		TransportTLS:   "1.1.1.3:853",
+		TransportUDP:   "1.1.1.3:53",
+		TransportTCP:   "1.1.1.3:53",
+		TransportHTTPS: "https://family.cloudflare-dns.com/dns-query",
+	},
+}
+
+// Upstream sends DNS messages to a recursive resolver.
+type Upstream interface {
+	// Exchange sends the DNS message and returns the response together with the round trip time.
+	Exchange(message *dns.Msg) (response *dns.Msg, duration time.Duration, err error)
+	// String returns a short description of the upstream which is shown to Discord users (e.g. "DoT 1.1.1.1:853").
+	String() string
+}
+
+// UpstreamConfig contains the settings which are used to create an Upstream.
+type UpstreamConfig struct {
+	// Transport is one of TransportTLS, TransportUDP, TransportTCP or TransportHTTPS.
+	Transport string
+	// Address is the address (host:port) or, for DNS over HTTPS, the URL of the resolver. If it is empty, the
+	// 1.1.1.1 DNS service is used.
+	Address string
+	// HTTPMethod is the HTTP method (GET or POST) used for DNS over HTTPS.
+	HTTPMethod string
+	// Timeout is the cumulative timeout of a single exchange.
+	Timeout time.Duration
+	// TLSServerName is the name which is verified against the certificate of a DNS over TLS resolver. If it is empty,
+	// the host of the address is used.
+	TLSServerName string
+	// TLSCAFile is the path of a PEM encoded CA bundle which replaces the system pool for DNS over TLS.
+	TLSCAFile string
+	// SPKIPins contains base64 encoded SHA-256 fingerprints of the SubjectPublicKeyInfo of which at least one has to be
+	// presented by a DNS over TLS resolver (RFC 7858 out-of-band key-pinned privacy profile).
+	SPKIPins []string
+}
+
+// NewUpstream creates an Upstream from the given configuration.
+func NewUpstream(config UpstreamConfig) (Upstream, error) {
+	address := config.Address
+	if address == "" {
+		address = defaultUpstreamAddresses[config.Transport]
+	}
+	timeout := config.Timeout
+	if timeout == 0 {
+		timeout = defaultUpstreamTimeout
+	}
+	switch config.Transport {
+	case TransportTLS:
+		if config.TLSServerName == "" {
+			if host, _, err := net.SplitHostPort(address); err == nil {
+				config.TLSServerName = host
+			}
+		}
+		tlsConfig, err := newTLSConfig(config)
+		if err != nil {
+			return nil, err
+		}
+		return &TLSUpstream{
+			Client:  &dns.Client{Net: "tcp-tls", Timeout: timeout, TLSConfig: tlsConfig},
+			Address: address,
+		}, nil
+	case TransportUDP, TransportTCP:
+		return &PlainUpstream{Address: address, Network: config.Transport, Timeout: timeout}, nil
+	case TransportHTTPS:
+		method := strings.ToUpper(config.HTTPMethod)
+		if method == "" {
+			method = http.MethodPost
+		}
+		if method != http.MethodPost && method != http.MethodGet {
+			return nil, fmt.Errorf("unsupported DNS over HTTPS method %q", config.HTTPMethod)
+		}
+		return &HTTPSUpstream{
+			URL:    address,
+			Method: method,
+			Client: &http.Client{Timeout: timeout},
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown upstream transport %q", config.Transport)
+	}
+}
+
+// NewProfileUpstreams creates an Upstream for every filtering resolver profile of the 1.1.1.1 DNS service (see
+// ProfileSecurity and ProfileFamily). The profiles use the transport, HTTP method and timeout of the given
+// configuration but always the Cloudflare defaults otherwise: the address, server name, CA file and SPKI pins of a
+// custom upstream do not apply to the Cloudflare resolvers.
+func NewProfileUpstreams(config UpstreamConfig) (map[string]Upstream, error) {
+	upstreams := make(map[string]Upstream, len(profileUpstreamAddresses))
+	for profile, addresses := range profileUpstreamAddresses {
+		profileConfig := UpstreamConfig{
+			Transport:  config.Transport,
+			Address:    addresses[config.Transport],
+			HTTPMethod: config.HTTPMethod,
+			Timeout:    config.Timeout,
+		}
+		upstream, err := NewUpstream(profileConfig)
+		if err != nil {
+			return nil, err
+		}
+		upstreams[profile] = upstream
+	}
+	return upstreams, nil
+}
+
+// TLSUpstream sends DNS messages over TLS (RFC 7858).
+type TLSUpstream struct {
+	// Client is the miekg dns client which has to use the "tcp-tls" network.
+	Client *dns.Client
+	// Address is the address (host:port) of the resolver.
+	Address string
+}
+
+// Exchange sends the DNS message over TLS.
+func (upstream *TLSUpstream) Exchange(message *dns.Msg) (*dns.Msg, time.Duration, error) {
+	return upstream.Client.Exchange(message, upstream.Address)
+}
+
+func (upstream *TLSUpstream) String() string {
+	return "DoT " + upstream.Address
+}
+
+// PlainUpstream sends unencrypted DNS messages over UDP or TCP.
+type PlainUpstream struct {
+	// Address is the address (host:port) of the resolver.
+	Address string
+	// Network is either "udp" or "tcp". Truncated UDP responses are retried over TCP.
+	Network string
+	// Timeout is the cumulative timeout of a single exchange.
+	Timeout time.Duration
+}
+
+// Exchange sends the DNS message over UDP or TCP.
+func (upstream *PlainUpstream) Exchange(message *dns.Msg) (*dns.Msg, time.Duration, error) {
+	client := &dns.Client{Net: upstream.Network, UDPSize: dns.DefaultMsgSize, Timeout: upstream.Timeout}
+	response, duration, err := client.Exchange(message, upstream.Address)
+	if err == nil && response.Truncated && upstream.Network == TransportUDP {
+		client.Net = TransportTCP
+		return client.Exchange(message, upstream.Address)
+	}
+	return response, duration, err
+}
+
+func (upstream *PlainUpstream) String() string {
+	return strings.ToUpper(upstream.Network) + " " + upstream.Address
+}
+
+// HTTPSUpstream sends DNS messages in wire format over HTTPS (RFC 8484).
+type HTTPSUpstream struct {
+	// URL is the URL of the DNS over HTTPS endpoint (e.g. https://cloudflare-dns.com/dns-query).
+	URL string
+	// Method is either GET or POST.
+	Method string
+	// Client is the HTTP client used to send the requests.
+	Client *http.Client
+}
+
+// Exchange sends the DNS message over HTTPS.
+func (upstream *HTTPSUpstream) Exchange(message *dns.Msg) (*dns.Msg, time.Duration, error) {
+	// RFC 8484 recommends the ID 0 to make responses cache friendly
+	id := message.Id
+	message.Id = 0
+	packedMessage, err := message.Pack()
+	message.Id = id
+	if err != nil {
+		return nil, 0, err
+	}
+	var request *http.Request
+	if upstream.Method == http.MethodGet {
+		var requestURL *url.URL
+		if requestURL, err = url.Parse(upstream.URL); err != nil {
+			return nil, 0, err
+		}
+		// keep the query parameters of the configured endpoint
+		query := requestURL.Query()
+		query.Set("dns", base64.RawURLEncoding.EncodeToString(packedMessage))
+		requestURL.RawQuery = query.Encode()
+		request, err = http.NewRequest(http.MethodGet, requestURL.String(), nil)
+	} else {
+		request, err = http.NewRequest(http.MethodPost, upstream.URL, bytes.NewReader(packedMessage))
+		if request != nil {
+			request.Header.Set("Content-Type", dNSMessageContentType)
+		}
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	request.Header.Set("Accept", dNSMessageContentType)
+	start := time.Now()
+	resp, err := upstream.Client.Do(request)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	duration := time.Since(start)
+	if err != nil {
+		return nil, duration, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, duration, fmt.Errorf("unexpected HTTP status code %d", resp.StatusCode)
+	}
+	if contentType := resp.Header.Get("Content-Type"); !strings.HasPrefix(contentType, dNSMessageContentType) {
+		return nil, duration, fmt.Errorf("unexpected content type %q", contentType)
+	}
+	response := &dns.Msg{}
+	if err := response.Unpack(body); err != nil {
+		return nil, duration, err
+	}
+	response.Id = id
+	return response, duration, nil
+}
+
+func (upstream *HTTPSUpstream) String() string {
+	return fmt.Sprintf("DoH %s %s", upstream.Method, upstream.URL)
+}
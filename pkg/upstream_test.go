@@ -0,0 +1,161 @@
+package discord1111resolver
+
+import (
+	"encoding/base64"
+	"github.com/miekg/dns"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newDoHTestServer starts a DNS over HTTPS stand-in which decodes the query of GET and POST requests, passes it to the
+// check function and answers with an A record for the queried name.
+func newDoHTestServer(t *testing.T, check func(request *http.Request, query *dns.Msg)) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		var packedQuery []byte
+		var err error
+		if request.Method == http.MethodGet {
+			packedQuery, err = base64.RawURLEncoding.DecodeString(request.URL.Query().Get("dns"))
+		} else {
+			packedQuery, err = ioutil.ReadAll(request.Body)
+		}
+		if err != nil {
+			t.Errorf("could not read the query: %v", err)
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+		query := &dns.Msg{}
+		if err := query.Unpack(packedQuery); err != nil {
+			t.Errorf("could not unpack the query: %v", err)
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+		check(request, query)
+		response := &dns.Msg{}
+		response.SetReply(query)
+		record, _ := dns.NewRR(query.Question[0].Name + " 300 IN A 192.0.2.1")
+		response.Answer = append(response.Answer, record)
+		packedResponse, _ := response.Pack()
+		writer.Header().Set("Content-Type", dNSMessageContentType)
+		writer.Write(packedResponse)
+	}))
+}
+
+func TestHTTPSUpstreamExchange(t *testing.T) {
+	for _, method := range []string{http.MethodGet, http.MethodPost} {
+		t.Run(method, func(t *testing.T) {
+			server := newDoHTestServer(t, func(request *http.Request, query *dns.Msg) {
+				if request.Method != method {
+					t.Errorf("expected method %s, got %s", method, request.Method)
+				}
+				if query.Id != 0 {
+					t.Errorf("expected the ID 0 on the wire, got %d", query.Id)
+				}
+				if accept := request.Header.Get("Accept"); accept != dNSMessageContentType {
+					t.Errorf("unexpected Accept header %q", accept)
+				}
+				switch method {
+				case http.MethodGet:
+					if !strings.HasPrefix(request.URL.RawQuery, "dns=") {
+						t.Errorf("unexpected query string %q", request.URL.RawQuery)
+					}
+				case http.MethodPost:
+					if contentType := request.Header.Get("Content-Type"); contentType != dNSMessageContentType {
+						t.Errorf("unexpected Content-Type %q", contentType)
+					}
+				}
+			})
+			defer server.Close()
+			upstream := &HTTPSUpstream{URL: server.URL, Method: method, Client: server.Client()}
+			message := &dns.Msg{}
+			message.SetQuestion("example.com.", dns.TypeA)
+			message.Id = 4242
+			response, _, err := upstream.Exchange(message)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if message.Id != 4242 {
+				t.Errorf("the ID of the query was not restored, got %d", message.Id)
+			}
+			if response.Id != 4242 {
+				t.Errorf("expected the response ID 4242, got %d", response.Id)
+			}
+			if len(response.Answer) != 1 || response.Answer[0].(*dns.A).A.String() != "192.0.2.1" {
+				t.Errorf("unexpected answer %v", response.Answer)
+			}
+		})
+	}
+}
+
+func TestHTTPSUpstreamGETEncoding(t *testing.T) {
+	message := &dns.Msg{}
+	// the packed query of this name is encoded with "+" and padding by the standard base64 encoding
+	message.SetQuestion("~~~~.example.com.", dns.TypeA)
+	message.Id = 1
+	expectedQuery := message.Copy()
+	expectedQuery.Id = 0
+	packedQuery, err := expectedQuery.Pack()
+	if err != nil {
+		t.Fatalf("could not pack the query: %v", err)
+	}
+	encodedQuery := base64.RawURLEncoding.EncodeToString(packedQuery)
+	tests := []struct {
+		name     string
+		query    string
+		expected string
+	}{
+		{name: "plain endpoint", expected: "dns=" + encodedQuery},
+		{name: "endpoint with a query string", query: "?ct=1", expected: "ct=1&dns=" + encodedQuery},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var rawQuery string
+			server := newDoHTestServer(t, func(request *http.Request, query *dns.Msg) {
+				rawQuery = request.URL.RawQuery
+			})
+			defer server.Close()
+			upstream := &HTTPSUpstream{URL: server.URL + test.query, Method: http.MethodGet, Client: server.Client()}
+			if _, _, err := upstream.Exchange(message); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			// the dns parameter has to be base64url encoded without padding (RFC 8484 section 4.1)
+			if rawQuery != test.expected {
+				t.Errorf("expected the query string %q, got %q", test.expected, rawQuery)
+			}
+			if strings.ContainsAny(encodedQuery, "+/=") {
+				t.Errorf("the dns parameter %q is not base64url encoded without padding", encodedQuery)
+			}
+		})
+	}
+}
+
+func TestHTTPSUpstreamErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      int
+		contentType string
+		expected    string
+	}{
+		{name: "status", status: http.StatusInternalServerError, contentType: dNSMessageContentType, expected: "status code 500"},
+		{name: "content type", status: http.StatusOK, contentType: "text/html", expected: "content type"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+				writer.Header().Set("Content-Type", test.contentType)
+				writer.WriteHeader(test.status)
+				writer.Write([]byte("<html></html>"))
+			}))
+			defer server.Close()
+			upstream := &HTTPSUpstream{URL: server.URL, Method: http.MethodPost, Client: server.Client()}
+			message := &dns.Msg{}
+			message.SetQuestion("example.com.", dns.TypeA)
+			_, _, err := upstream.Exchange(message)
+			if err == nil || !strings.Contains(err.Error(), test.expected) {
+				t.Errorf("expected an error containing %q, got %v", test.expected, err)
+			}
+		})
+	}
+}
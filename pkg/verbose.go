@@ -0,0 +1,123 @@
+package discord1111resolver
+
+import (
+	"fmt"
+	"github.com/bwmarrin/discordgo"
+	"github.com/miekg/dns"
+	"strings"
+)
+
+const (
+	// verboseHeaderFormat is used to render the header of a DNS response like dig does.
+	verboseHeaderFormat = "opcode: %s, status: %s, id: %d\nflags: %s"
+	// codeBlockFormat wraps a value in a Discord code block.
+	codeBlockFormat = "```\n%s\n```"
+	// omittedRecordsFormat is appended to a code block if not all records fit into a field.
+	omittedRecordsFormat = "... %d more"
+)
+
+func (resolveHandler *ResolveHandler) handleDigCommand(messageCreate *discordgo.MessageCreate,
+	messageSend *messageReply, options *queryOptions, params []string) (ok bool) {
+	options.verbose = true
+	return resolveHandler.handleQuery(messageCreate, messageSend, options, params)
+}
+
+// verboseFields renders the complete DNS response (header flags and every section including TTLs) like dig does.
+func verboseFields(response *dns.Msg) []*discordgo.MessageEmbedField {
+	fields := []*discordgo.MessageEmbedField{{
+		Name: "Header:",
+		Value: fmt.Sprintf(verboseHeaderFormat, dns.OpcodeToString[response.Opcode], dns.RcodeToString[response.Rcode],
+			response.Id, formatHeaderFlags(response)),
+	}}
+	questions := make([]string, len(response.Question))
+	for index, question := range response.Question {
+		questions[index] = fmt.Sprintf("%s %s %s", question.Name, dns.ClassToString[question.Qclass],
+			dns.TypeToString[question.Qtype])
+	}
+	fields = append(fields, verboseSectionField("Question section:", questions))
+	sections := []struct {
+		name    string
+		records []dns.RR
+	}{
+		{name: "Answer section:", records: response.Answer},
+		{name: "Authority section:", records: response.Ns},
+		{name: "Additional section:", records: response.Extra},
+	}
+	for _, section := range sections {
+		var lines []string
+		for _, record := range section.records {
+			// the OPT pseudo-record is rendered in its own field
+			if record.Header().Rrtype == dns.TypeOPT {
+				continue
+			}
+			lines = append(lines, record.String())
+		}
+		if len(lines) > 0 {
+			fields = append(fields, verboseSectionField(section.name, lines))
+		}
+	}
+	if option := response.IsEdns0(); option != nil {
+		fields = append(fields, verboseSectionField("OPT pseudo-section:",
+			strings.Split(strings.TrimSpace(strings.TrimPrefix(option.String(), "\n;; OPT PSEUDOSECTION:\n")), "\n")))
+	}
+	return fields
+}
+
+// formatHeaderFlags renders the flags of the message header which are set (e.g. "qr rd ra").
+func formatHeaderFlags(message *dns.Msg) string {
+	flags := []struct {
+		name string
+		set  bool
+	}{
+		{name: "qr", set: message.Response},
+		{name: "aa", set: message.Authoritative},
+		{name: "tc", set: message.Truncated},
+		{name: "rd", set: message.RecursionDesired},
+		{name: "ra", set: message.RecursionAvailable},
+		{name: "ad", set: message.AuthenticatedData},
+		{name: "cd", set: message.CheckingDisabled},
+	}
+	setFlags := make([]string, 0, len(flags))
+	for _, flag := range flags {
+		if flag.set {
+			setFlags = append(setFlags, flag.name)
+		}
+	}
+	if len(setFlags) == 0 {
+		return "none"
+	}
+	return strings.Join(setFlags, " ")
+}
+
+// verboseSectionField renders the lines of a section as a code block which fits into a single field value.
+func verboseSectionField(name string, lines []string) *discordgo.MessageEmbedField {
+	return &discordgo.MessageEmbedField{
+		Name:  name,
+		Value: formatCodeBlock(lines),
+	}
+}
+
+// formatCodeBlock renders the lines as a code block. If not all lines fit into a field value, the remaining lines are
+// omitted and counted.
+func formatCodeBlock(lines []string) string {
+	maximumContentLength := maximumValueLength - len(fmt.Sprintf(codeBlockFormat, "")) -
+		len(fmt.Sprintf(omittedRecordsFormat, len(lines))) - 1
+	content := make([]string, 0, len(lines))
+	contentLength := 0
+	for index, line := range lines {
+		if contentLength+len(line)+1 > maximumContentLength {
+			if index == 0 {
+				// a single line which is too long is shortened instead
+				content = append(content, line[:maximumContentLength-3]+"...")
+				index++
+			}
+			if index < len(lines) {
+				content = append(content, fmt.Sprintf(omittedRecordsFormat, len(lines)-index))
+			}
+			break
+		}
+		content = append(content, line)
+		contentLength += len(line) + 1
+	}
+	return fmt.Sprintf(codeBlockFormat, strings.Join(content, "\n"))
+}
@@ -0,0 +1,421 @@
+package discord1111resolver
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/bwmarrin/discordgo"
+	"github.com/miekg/dns"
+	"github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// watchIntervalKeyword introduces the polling interval of the watch command (e.g. every 5m).
+	watchIntervalKeyword = "every"
+	// watchListKeyword lists the watch subscriptions of the channel instead of creating one.
+	watchListKeyword = "list"
+	// minimumWatchInterval is the minimum polling interval of a watch subscription.
+	minimumWatchInterval = time.Minute
+	// maximumChannelWatches is the maximum number of watch subscriptions of a single channel.
+	maximumChannelWatches = 10
+	// watchFilePermissions are the file permissions of the file the watch subscriptions are persisted to.
+	watchFilePermissions = 0600
+	// watchChangeFormat is used to describe a change of a watched RRset.
+	watchChangeFormat = "The answer of `%s %s` changed (watch #%d)."
+)
+
+// watchSubscription is a persisted subscription which polls a DNS question and notifies a channel on changes.
+type watchSubscription struct {
+	// ID identifies the subscription, e.g. to remove it with the unwatch command.
+	ID int `json:"id"`
+	// ChannelID is the ID of the Discord channel which is notified.
+	ChannelID string `json:"channel_id"`
+	// AuthorID is the ID of the Discord user who created the subscription.
+	AuthorID string `json:"author_id"`
+	// Type is the name of the watched record type.
+	Type string `json:"type"`
+	// Domain is the watched fully qualified domain name.
+	Domain string `json:"domain"`
+	// Interval is the polling interval.
+	Interval time.Duration `json:"interval"`
+	// Answer contains the sorted answer (or the response code if the domain does not exist) of the last poll.
+	Answer []string `json:"answer"`
+	// stop is closed to stop polling.
+	stop chan struct{}
+}
+
+// watchRegistry contains every watch subscription and persists them to a file.
+type watchRegistry struct {
+	sync.Mutex
+	// file is the path of the JSON file the subscriptions are persisted to (empty if they are not persisted).
+	file string
+	// session is the Discord session which is used to send notifications.
+	session *discordgo.Session
+	// subscriptions contains every subscription indexed by its ID.
+	subscriptions map[int]*watchSubscription
+	// nextID is the ID of the next subscription.
+	nextID int
+}
+
+// StartWatches loads the persisted watch subscriptions from the WatchFile and starts polling them. It has to be called
+// after Initialize because notifications are sent with the given session. Watch commands are rejected until it has
+// been called.
+func (resolveHandler *ResolveHandler) StartWatches(session *discordgo.Session) error {
+	registry := &watchRegistry{
+		file:          resolveHandler.WatchFile,
+		session:       session,
+		subscriptions: make(map[int]*watchSubscription),
+		nextID:        1,
+	}
+	if registry.file != "" {
+		content, err := ioutil.ReadFile(registry.file)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		var subscriptions []*watchSubscription
+		if len(content) > 0 {
+			if err := json.Unmarshal(content, &subscriptions); err != nil {
+				return err
+			}
+		}
+		for _, subscription := range subscriptions {
+			subscription.stop = make(chan struct{})
+			registry.subscriptions[subscription.ID] = subscription
+			if subscription.ID >= registry.nextID {
+				registry.nextID = subscription.ID + 1
+			}
+		}
+	}
+	resolveHandler.watches = registry
+	for _, subscription := range registry.subscriptions {
+		resolveHandler.startWatch(subscription)
+	}
+	logrus.WithField("subscriptions", len(registry.subscriptions)).Info("started watch subscriptions")
+	return nil
+}
+
+// StopWatches stops polling every watch subscription.
+func (resolveHandler *ResolveHandler) StopWatches() {
+	registry := resolveHandler.watches
+	if registry == nil {
+		return
+	}
+	registry.Lock()
+	defer registry.Unlock()
+	for _, subscription := range registry.subscriptions {
+		close(subscription.stop)
+	}
+	registry.subscriptions = make(map[int]*watchSubscription)
+}
+
+func (resolveHandler *ResolveHandler) handleWatchCommand(messageCreate *discordgo.MessageCreate,
+	messageSend *messageReply, options *queryOptions, params []string) (ok bool) {
+	messageEmbed := messageSend.Embed
+	if !resolveHandler.checkWatchesEnabled(messageEmbed) {
+		return false
+	}
+	if len(params) == 1 && strings.EqualFold(params[0], watchListKeyword) {
+		return resolveHandler.listWatches(messageCreate, messageEmbed)
+	}
+	if len(params) != 4 || !strings.EqualFold(params[2], watchIntervalKeyword) {
+		return false
+	}
+	recordType, ok := validateDNSMessageTypeParam(messageEmbed, params[0])
+	if !ok {
+		return false
+	}
+	domain, ok := prepareDomainName(messageEmbed, params[1])
+	if !ok {
+		return false
+	}
+	interval, err := time.ParseDuration(params[3])
+	if err != nil || interval < minimumWatchInterval {
+		intervalString := params[3]
+		trimDiscordFieldValue(&intervalString)
+		messageEmbed.Fields = []*discordgo.MessageEmbedField{{
+			Name:   fmt.Sprintf("Invalid interval (at least %v):", minimumWatchInterval),
+			Value:  strconv.Quote(intervalString),
+			Inline: true,
+		}}
+		return false
+	}
+	// checked before polling to fail fast, the limit is enforced when the subscription is added
+	if len(resolveHandler.channelWatches(messageCreate.ChannelID)) >= maximumChannelWatches {
+		setTooManyWatchesField(messageEmbed)
+		return false
+	}
+	answer, err := resolveHandler.pollWatchAnswer(recordType, domain)
+	if err != nil {
+		messageEmbed.Fields = []*discordgo.MessageEmbedField{{
+			Name:   "Unknown error while executing the DNS request:",
+			Value:  strconv.Quote(err.Error()),
+			Inline: true,
+		}}
+		return false
+	}
+	subscription := &watchSubscription{
+		ChannelID: messageCreate.ChannelID,
+		AuthorID:  messageCreate.Author.ID,
+		Type:      recordType.name,
+		Domain:    domain,
+		Interval:  interval,
+		Answer:    answer,
+		stop:      make(chan struct{}),
+	}
+	if !resolveHandler.watches.add(subscription, maximumChannelWatches) {
+		setTooManyWatchesField(messageEmbed)
+		return false
+	}
+	resolveHandler.startWatch(subscription)
+	messageEmbed.Description = fmt.Sprintf("Watching `%s %s` every %v (watch #%d). This channel is notified when the answer changes.",
+		subscription.Type, subscription.Domain, subscription.Interval, subscription.ID)
+	messageEmbed.Fields = []*discordgo.MessageEmbedField{verboseSectionField("Current answer:", answer)}
+	return true
+}
+
+func (resolveHandler *ResolveHandler) handleUnwatchCommand(messageCreate *discordgo.MessageCreate,
+	messageSend *messageReply, options *queryOptions, params []string) (ok bool) {
+	messageEmbed := messageSend.Embed
+	if !resolveHandler.checkWatchesEnabled(messageEmbed) {
+		return false
+	}
+	if len(params) != 1 {
+		return false
+	}
+	id, err := strconv.Atoi(strings.TrimPrefix(params[0], "#"))
+	registry := resolveHandler.watches
+	registry.Lock()
+	defer registry.Unlock()
+	subscription, found := registry.subscriptions[id]
+	// subscriptions can only be removed from the channel which is notified
+	if err != nil || !found || subscription.ChannelID != messageCreate.ChannelID {
+		idString := params[0]
+		trimDiscordFieldValue(&idString)
+		messageEmbed.Fields = []*discordgo.MessageEmbedField{{
+			Name:   "Unknown watch subscription:",
+			Value:  strconv.Quote(idString),
+			Inline: true,
+		}}
+		return false
+	}
+	close(subscription.stop)
+	delete(registry.subscriptions, id)
+	if err := registry.save(); err != nil {
+		logrus.WithError(err).WithField("file", registry.file).Warn("could not persist watch subscriptions")
+	}
+	messageEmbed.Fields = []*discordgo.MessageEmbedField{{
+		Name:   "Removed watch subscription:",
+		Value:  fmt.Sprintf("#%d `%s %s`", subscription.ID, subscription.Type, subscription.Domain),
+		Inline: true,
+	}}
+	return true
+}
+
+// setTooManyWatchesField sets the error field of a channel which already has the maximum number of subscriptions.
+func setTooManyWatchesField(messageEmbed *discordgo.MessageEmbed) {
+	messageEmbed.Fields = []*discordgo.MessageEmbedField{{
+		Name:   "Too many watch subscriptions:",
+		Value:  fmt.Sprintf("A channel may have at most %d watch subscriptions, remove one with unwatch.", maximumChannelWatches),
+		Inline: true,
+	}}
+}
+
+// checkWatchesEnabled checks whether StartWatches was called and sets an error field if not.
+func (resolveHandler *ResolveHandler) checkWatchesEnabled(messageEmbed *discordgo.MessageEmbed) bool {
+	if resolveHandler.watches != nil {
+		return true
+	}
+	messageEmbed.Fields = []*discordgo.MessageEmbedField{{
+		Name:   "Watch subscriptions are disabled:",
+		Value:  "This bot instance does not support watch subscriptions.",
+		Inline: true,
+	}}
+	return false
+}
+
+// listWatches lists the watch subscriptions of the channel.
+func (resolveHandler *ResolveHandler) listWatches(messageCreate *discordgo.MessageCreate, messageEmbed *discordgo.MessageEmbed) bool {
+	subscriptions := resolveHandler.channelWatches(messageCreate.ChannelID)
+	if len(subscriptions) == 0 {
+		messageEmbed.Fields = []*discordgo.MessageEmbedField{{
+			Name:   "Watch subscriptions:",
+			Value:  "This channel has no watch subscriptions.",
+			Inline: true,
+		}}
+		return true
+	}
+	messageEmbed.Fields = make([]*discordgo.MessageEmbedField, len(subscriptions))
+	for index, subscription := range subscriptions {
+		messageEmbed.Fields[index] = verboseSectionField(fmt.Sprintf("#%d %s %s every %v:", subscription.ID,
+			subscription.Type, subscription.Domain, subscription.Interval), subscription.Answer)
+	}
+	return true
+}
+
+// channelWatches returns copies of the watch subscriptions of the channel sorted by their ID. Copies are returned
+// because the answers are updated by the polling goroutines.
+func (resolveHandler *ResolveHandler) channelWatches(channelID string) []*watchSubscription {
+	registry := resolveHandler.watches
+	registry.Lock()
+	defer registry.Unlock()
+	var subscriptions []*watchSubscription
+	for _, subscription := range registry.subscriptions {
+		if subscription.ChannelID == channelID {
+			subscriptionCopy := *subscription
+			subscriptions = append(subscriptions, &subscriptionCopy)
+		}
+	}
+	sort.Slice(subscriptions, func(i, j int) bool {
+		return subscriptions[i].ID < subscriptions[j].ID
+	})
+	return subscriptions
+}
+
+// startWatch starts polling the subscription in the background until its stop channel is closed.
+func (resolveHandler *ResolveHandler) startWatch(subscription *watchSubscription) {
+	go func() {
+		ticker := time.NewTicker(subscription.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-subscription.stop:
+				return
+			case <-ticker.C:
+				resolveHandler.pollWatch(subscription)
+			}
+		}
+	}()
+}
+
+// pollWatch queries the watched question and notifies the channel if the answer changed.
+func (resolveHandler *ResolveHandler) pollWatch(subscription *watchSubscription) {
+	recordType, ok := validateDNSMessageType(subscription.Type)
+	if !ok {
+		return
+	}
+	answer, err := resolveHandler.pollWatchAnswer(recordType, subscription.Domain)
+	if err != nil {
+		// temporary errors are not reported to avoid notifications about unreachable upstreams
+		logrus.WithError(err).WithField("watch-id", subscription.ID).Debug("could not poll watch subscription")
+		return
+	}
+	registry := resolveHandler.watches
+	registry.Lock()
+	// the subscription may have been removed while the query was in flight
+	if registry.subscriptions[subscription.ID] != subscription {
+		registry.Unlock()
+		return
+	}
+	previousAnswer := subscription.Answer
+	changed := strings.Join(previousAnswer, "\n") != strings.Join(answer, "\n")
+	if changed {
+		subscription.Answer = answer
+		if err := registry.save(); err != nil {
+			logrus.WithError(err).WithField("file", registry.file).Warn("could not persist watch subscriptions")
+		}
+	}
+	registry.Unlock()
+	if !changed {
+		return
+	}
+	messageEmbed := &discordgo.MessageEmbed{
+		Title:       embedTitle,
+		URL:         baseURL,
+		Color:       baseColor,
+		Description: fmt.Sprintf(watchChangeFormat, subscription.Type, subscription.Domain, subscription.ID),
+		Fields: []*discordgo.MessageEmbedField{
+			verboseSectionField("Before:", previousAnswer),
+			verboseSectionField("After:", answer),
+		},
+		Footer: &discordgo.MessageEmbedFooter{
+			Text: fmt.Sprintf("Polled every %v via %s. Stop with unwatch %d.", subscription.Interval,
+				resolveHandler.Upstream.String(), subscription.ID),
+		},
+	}
+	if _, err := registry.session.ChannelMessageSendEmbed(subscription.ChannelID, messageEmbed); err != nil {
+		logrus.WithError(err).WithField("channel-id", subscription.ChannelID).Warn("could not send watch notification")
+	}
+}
+
+// pollWatchAnswer queries the upstream resolver and returns the sorted answer or the response code if the domain does
+// not exist. Other response codes (e.g. SERVFAIL or REFUSED) are returned as errors because they are usually temporary.
+func (resolveHandler *ResolveHandler) pollWatchAnswer(recordType *dNSRecordType, domain string) ([]string, error) {
+	message := &dns.Msg{}
+	message.SetQuestion(domain, recordType.messageType)
+	response, _, err := resolveHandler.exchange(message)
+	if err != nil {
+		return nil, err
+	}
+	if errorMessage, ok := validateDNSResponseCode(response.Rcode); !ok {
+		if response.Rcode != dns.RcodeNameError {
+			return nil, fmt.Errorf("non-successful response code: %s", errorMessage)
+		}
+		return []string{errorMessage}, nil
+	}
+	var answer []string
+	for _, record := range response.Answer {
+		// the TTLs change with every poll, the owner name and type tell the links of a CNAME chain apart
+		recordCopy := dns.Copy(record)
+		recordCopy.Header().Ttl = 0
+		answer = append(answer, recordCopy.String())
+	}
+	if len(answer) == 0 {
+		answer = []string{"no records"}
+	}
+	sort.Strings(answer)
+	return answer, nil
+}
+
+// add assigns an ID to the subscription and registers and persists it unless its channel already has limit
+// subscriptions. The check and the insert happen under the same lock so that concurrent commands cannot exceed the
+// limit.
+func (registry *watchRegistry) add(subscription *watchSubscription, limit int) (added bool) {
+	registry.Lock()
+	defer registry.Unlock()
+	channelSubscriptions := 0
+	for _, existingSubscription := range registry.subscriptions {
+		if existingSubscription.ChannelID == subscription.ChannelID {
+			channelSubscriptions++
+		}
+	}
+	if channelSubscriptions >= limit {
+		return false
+	}
+	subscription.ID = registry.nextID
+	registry.nextID++
+	registry.subscriptions[subscription.ID] = subscription
+	if err := registry.save(); err != nil {
+		logrus.WithError(err).WithField("file", registry.file).Warn("could not persist watch subscriptions")
+	}
+	return true
+}
+
+// save persists the subscriptions to the watch file. The registry has to be locked.
+func (registry *watchRegistry) save() error {
+	if registry.file == "" {
+		return nil
+	}
+	subscriptions := make([]*watchSubscription, 0, len(registry.subscriptions))
+	for _, subscription := range registry.subscriptions {
+		subscriptions = append(subscriptions, subscription)
+	}
+	sort.Slice(subscriptions, func(i, j int) bool {
+		return subscriptions[i].ID < subscriptions[j].ID
+	})
+	content, err := json.MarshalIndent(subscriptions, "", "  ")
+	if err != nil {
+		return err
+	}
+	// write to a temporary file first to never leave a partially written file behind
+	temporaryFile := registry.file + ".tmp"
+	if err := ioutil.WriteFile(temporaryFile, content, watchFilePermissions); err != nil {
+		return err
+	}
+	return os.Rename(temporaryFile, registry.file)
+}
@@ -0,0 +1,220 @@
+package discord1111resolver
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"github.com/bwmarrin/discordgo"
+	"github.com/miekg/dns"
+	"github.com/sirupsen/logrus"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// zoneIncludeDirective is the zone file directive which includes other files. It is refused because it would allow
+	// Discord users to read files of the host the bot is running on.
+	zoneIncludeDirective = "$INCLUDE"
+	// zoneSummaryFormat is used to summarize a validated zone file.
+	zoneSummaryFormat = "Parsed %d records of the zone `%s` from `%s`."
+)
+
+// zoneProblems contains the problems found in a zone file grouped by their kind.
+type zoneProblems struct {
+	// missingRecords contains records which are required at the zone apex (SOA and NS) but missing.
+	missingRecords []string
+	// outOfZone contains records whose owner name is not part of the zone.
+	outOfZone []string
+	// cNAMEConflicts contains owner names which own a CNAME record and other data.
+	cNAMEConflicts []string
+	// duplicates contains records which occur more than once (TTLs are ignored).
+	duplicates []string
+}
+
+func (resolveHandler *ResolveHandler) handleValidateCommand(messageCreate *discordgo.MessageCreate,
+	messageSend *messageReply, options *queryOptions, params []string) (ok bool) {
+	messageEmbed := messageSend.Embed
+	if len(params) > 1 {
+		return false
+	}
+	if len(messageCreate.Attachments) == 0 {
+		messageEmbed.Fields = []*discordgo.MessageEmbedField{{
+			Name:   "No zone file attached:",
+			Value:  "Attach the zone file to the message which contains the validate command.",
+			Inline: true,
+		}}
+		return false
+	}
+	// the origin is used for relative names if the zone file does not contain an $ORIGIN directive
+	var origin string
+	if len(params) == 1 {
+		if origin, ok = prepareDomainName(messageEmbed, params[0]); !ok {
+			return false
+		}
+	}
+	attachment := messageCreate.Attachments[0]
+	content, err := downloadAttachment(attachment)
+	if err != nil {
+		logrus.WithError(err).WithField("url", attachment.URL).Warn("could not download zone file")
+		messageEmbed.Fields = []*discordgo.MessageEmbedField{{
+			Name:   "Could not download the zone file:",
+			Value:  strconv.Quote(err.Error()),
+			Inline: true,
+		}}
+		return false
+	}
+	if line, found := findZoneIncludeDirective(content); found {
+		messageEmbed.Fields = []*discordgo.MessageEmbedField{{
+			Name:   "Unsupported directive:",
+			Value:  fmt.Sprintf("The %s directive in line %d is not supported, only single zone files can be validated.", zoneIncludeDirective, line),
+			Inline: true,
+		}}
+		return false
+	}
+	var records []dns.RR
+	for token := range dns.ParseZone(bytes.NewReader(content), origin, attachment.Filename) {
+		if token.Error != nil {
+			// the parser stops at the first syntax error, the remaining checks would report misleading problems
+			messageEmbed.Description = fmt.Sprintf("Parsing `%s` stopped after %d records.", attachment.Filename, len(records))
+			messageEmbed.Fields = []*discordgo.MessageEmbedField{verboseSectionField("Syntax error:", []string{token.Error.Error()})}
+			return false
+		}
+		records = append(records, token.RR)
+	}
+	apex := findZoneApex(records, origin)
+	if apex == "" {
+		messageEmbed.Fields = []*discordgo.MessageEmbedField{{
+			Name:   "Missing records:",
+			Value:  "The zone file does not contain a SOA record and no origin was specified.",
+			Inline: true,
+		}}
+		return false
+	}
+	messageEmbed.Description = fmt.Sprintf(zoneSummaryFormat, len(records), apex, attachment.Filename)
+	problems := validateZone(records, apex)
+	messageEmbed.Fields = make([]*discordgo.MessageEmbedField, 0, 4)
+	problemFields := []struct {
+		name  string
+		lines []string
+	}{
+		{name: "Missing records:", lines: problems.missingRecords},
+		{name: "Out-of-zone data:", lines: problems.outOfZone},
+		{name: "CNAME and other data:", lines: problems.cNAMEConflicts},
+		{name: "Duplicate records:", lines: problems.duplicates},
+	}
+	for _, problemField := range problemFields {
+		if len(problemField.lines) > 0 {
+			messageEmbed.Fields = append(messageEmbed.Fields, verboseSectionField(problemField.name, problemField.lines))
+		}
+	}
+	if len(messageEmbed.Fields) > 0 {
+		return false
+	}
+	messageEmbed.Fields = append(messageEmbed.Fields, &discordgo.MessageEmbedField{
+		Name:   "Result:",
+		Value:  "No problems found.",
+		Inline: true,
+	})
+	return true
+}
+
+// findZoneIncludeDirective returns the line number of the first line which contains an $INCLUDE directive.
+func findZoneIncludeDirective(content []byte) (line int, found bool) {
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line++
+		if strings.Contains(strings.ToUpper(scanner.Text()), zoneIncludeDirective) {
+			return line, true
+		}
+	}
+	return 0, false
+}
+
+// findZoneApex returns the origin if it was specified or the owner name of the first SOA record.
+func findZoneApex(records []dns.RR, origin string) string {
+	if origin != "" {
+		return origin
+	}
+	for _, record := range records {
+		if record.Header().Rrtype == dns.TypeSOA {
+			return record.Header().Name
+		}
+	}
+	return ""
+}
+
+// validateZone checks the records of a zone for missing apex records, out-of-zone data, CNAME records with other data
+// and duplicates.
+func validateZone(records []dns.RR, apex string) *zoneProblems {
+	problems := &zoneProblems{}
+	soaRecords, nsRecords := 0, 0
+	ownerTypes := make(map[string]map[uint16]int)
+	var owners []string
+	seenRecords := make(map[string]bool)
+	for _, record := range records {
+		header := record.Header()
+		owner := strings.ToLower(header.Name)
+		if !dns.IsSubDomain(apex, owner) {
+			problems.outOfZone = append(problems.outOfZone, record.String())
+			continue
+		}
+		if dns.CountLabel(owner) == dns.CountLabel(apex) {
+			switch header.Rrtype {
+			case dns.TypeSOA:
+				soaRecords++
+			case dns.TypeNS:
+				nsRecords++
+			}
+		}
+		if _, found := ownerTypes[owner]; !found {
+			ownerTypes[owner] = make(map[uint16]int)
+			owners = append(owners, owner)
+		}
+		ownerTypes[owner][header.Rrtype]++
+		// records which only differ in their TTL or the case of their owner name are duplicates as well, the record
+		// data may be case sensitive (e.g. TXT)
+		recordCopy := dns.Copy(record)
+		recordCopy.Header().Ttl = 0
+		recordCopy.Header().Name = owner
+		key := recordCopy.String()
+		if seenRecords[key] {
+			problems.duplicates = append(problems.duplicates, record.String())
+		}
+		seenRecords[key] = true
+	}
+	switch {
+	case soaRecords == 0:
+		problems.missingRecords = append(problems.missingRecords, fmt.Sprintf("%s has no SOA record", apex))
+	case soaRecords > 1:
+		problems.missingRecords = append(problems.missingRecords, fmt.Sprintf("%s has %d SOA records (exactly one is allowed)", apex, soaRecords))
+	}
+	if nsRecords == 0 {
+		problems.missingRecords = append(problems.missingRecords, fmt.Sprintf("%s has no NS records", apex))
+	}
+	sort.Strings(owners)
+	for _, owner := range owners {
+		types := ownerTypes[owner]
+		if types[dns.TypeCNAME] == 0 {
+			continue
+		}
+		var otherTypes []string
+		for rrType := range types {
+			// DNSSEC records may coexist with a CNAME record (RFC 4035)
+			if rrType == dns.TypeCNAME || rrType == dns.TypeRRSIG || rrType == dns.TypeNSEC {
+				continue
+			}
+			otherTypes = append(otherTypes, dns.TypeToString[rrType])
+		}
+		sort.Strings(otherTypes)
+		switch {
+		case len(otherTypes) > 0:
+			problems.cNAMEConflicts = append(problems.cNAMEConflicts,
+				fmt.Sprintf("%s owns a CNAME and %s records", owner, strings.Join(otherTypes, ", ")))
+		case types[dns.TypeCNAME] > 1:
+			problems.cNAMEConflicts = append(problems.cNAMEConflicts,
+				fmt.Sprintf("%s owns %d CNAME records (exactly one is allowed)", owner, types[dns.TypeCNAME]))
+		}
+	}
+	return problems
+}
@@ -0,0 +1,99 @@
+package discord1111resolver
+
+import (
+	"github.com/miekg/dns"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// zoneTestApex contains the apex records of a valid test zone.
+const zoneTestApex = `$ORIGIN example.com.
+$TTL 3600
+@ IN SOA ns1 hostmaster 1 7200 900 1209600 300
+@ IN NS ns1
+ns1 IN A 192.0.2.1
+`
+
+// parseTestZone parses the zone file content of a test.
+func parseTestZone(t *testing.T, content string) []dns.RR {
+	var records []dns.RR
+	for token := range dns.ParseZone(strings.NewReader(content), "", "test.zone") {
+		if token.Error != nil {
+			t.Fatalf("could not parse the zone: %v", token.Error)
+		}
+		records = append(records, token.RR)
+	}
+	return records
+}
+
+func TestValidateZone(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		expected zoneProblems
+	}{
+		{
+			name:    "valid zone",
+			content: zoneTestApex + "www IN CNAME ns1\n",
+		},
+		{
+			name:    "missing SOA and NS",
+			content: "$ORIGIN example.com.\nwww 3600 IN A 192.0.2.1\n",
+			expected: zoneProblems{missingRecords: []string{"example.com. has no SOA record",
+				"example.com. has no NS records"}},
+		},
+		{
+			name:     "out-of-zone data",
+			content:  zoneTestApex + "www.example.org. IN A 192.0.2.2\n",
+			expected: zoneProblems{outOfZone: []string{"www.example.org.\t3600\tIN\tA\t192.0.2.2"}},
+		},
+		{
+			name:     "CNAME and other data",
+			content:  zoneTestApex + "www IN CNAME ns1\nwww IN TXT \"text\"\n",
+			expected: zoneProblems{cNAMEConflicts: []string{"www.example.com. owns a CNAME and TXT records"}},
+		},
+		{
+			name:     "several CNAME records",
+			content:  zoneTestApex + "www IN CNAME ns1\nwww IN CNAME ns2\n",
+			expected: zoneProblems{cNAMEConflicts: []string{"www.example.com. owns 2 CNAME records (exactly one is allowed)"}},
+		},
+		{
+			name: "CNAME with DNSSEC records",
+			content: zoneTestApex + "www IN CNAME ns1\n" +
+				"www IN NSEC ns1 CNAME RRSIG NSEC\n",
+		},
+		{
+			name:     "duplicates which only differ in the TTL",
+			content:  zoneTestApex + "www 60 IN A 192.0.2.2\nwww 120 IN A 192.0.2.2\n",
+			expected: zoneProblems{duplicates: []string{"www.example.com.\t120\tIN\tA\t192.0.2.2"}},
+		},
+		{
+			name:     "duplicates which only differ in the case of the owner name",
+			content:  zoneTestApex + "www IN A 192.0.2.2\nWWW IN A 192.0.2.2\n",
+			expected: zoneProblems{duplicates: []string{"WWW.example.com.\t3600\tIN\tA\t192.0.2.2"}},
+		},
+		{
+			name:    "TXT records which only differ in case",
+			content: zoneTestApex + "@ IN TXT \"Token\"\n@ IN TXT \"token\"\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			problems := validateZone(parseTestZone(t, test.content), "example.com.")
+			if !reflect.DeepEqual(*problems, test.expected) {
+				t.Errorf("expected %+v, got %+v", test.expected, *problems)
+			}
+		})
+	}
+}
+
+func TestFindZoneIncludeDirective(t *testing.T) {
+	line, found := findZoneIncludeDirective([]byte(zoneTestApex + "$include /etc/passwd\n"))
+	if !found || line != 6 {
+		t.Errorf("expected the directive in line 6, got %d (found: %t)", line, found)
+	}
+	if _, found := findZoneIncludeDirective([]byte(zoneTestApex)); found {
+		t.Error("found a directive in a zone without one")
+	}
+}